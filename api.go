@@ -2,74 +2,216 @@ package imageupsizer
 
 import (
 	"fmt"
+	"image"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
 
 // FindLargerImageFromFile takes a file and returns information about
 // a larger image that was found. It does NOT download the image.
+// When a Cache is set the outcome of an earlier search for the same image is returned from it,
+// images from the cache have no Bytes, unless they are downloaded to be scored for SelectBestQuality. Images that don't pass the Thresholds are rejected with a *ThresholdError.
 func FindLargerImageFromFile(filename string) (*ImageData, error) {
+	var largerImage, err = findLargerImage(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkThresholds(filename, largerImage); err != nil {
+		return nil, err
+	}
+	return largerImage, nil
+}
+
+// checkThresholds checks largerImage against the original file
+func checkThresholds(filename string, largerImage *ImageData) error {
+	originalImage, err := GetImageConfigFromFile(filename)
+	if err != nil {
+		return fmt.Errorf("error from GetImageConfigFromFile: %w", err)
+	}
+
+	if err := thresholds.Check(originalImage, largerImage); err != nil {
+		log.Tracef("[%s] Larger image %s rejected: %s", filename, largerImage.URL, err)
+		return err
+	}
+	return nil
+}
+
+// findLargerImage is FindLargerImageFromFile without the thresholds, the cache
+// records what the search found so changing the thresholds does not need a new search.
+func findLargerImage(filename string) (*ImageData, error) {
+	if cache == nil {
+		largerImage, _, err := searchLargerImage(filename)
+		return largerImage, err
+	}
+
+	hash, err := hashFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry, ok := cache.Get(hash); ok {
+		log.Tracef("[%s] Found in cache, found: %t, url: %s", filename, entry.Found, entry.URL)
+		if !entry.Found {
+			return nil, ErrNoLargerAvailable
+		}
+		var cached = &ImageData{
+			URL:        entry.URL,
+			Config:     image.Config{Width: entry.Width, Height: entry.Height},
+			Area:       entry.Width * entry.Height,
+			Provider:   entry.Provider,
+			SourcePage: entry.SourcePage,
+			Score:      entry.Score,
+		}
+		// an entry from a run that picked the largest image has no score, download the image so it can be compared
+		if selection == SelectBestQuality && cached.Score == nil {
+			if downloaded, err := getImage(cached.URL); err == nil {
+				cached.Bytes = downloaded.Bytes
+				cached.Extension = downloaded.Extension
+				cached.FileSize = downloaded.FileSize
+			} else {
+				log.Tracef("[%s] error downloading cached image to score it: %s", filename, err)
+			}
+		}
+		return cached, nil
+	}
+
+	largerImage, candidates, err := searchLargerImage(filename)
+	var entry = CacheEntry{Candidates: candidates}
+	switch {
+	case err == nil:
+		entry.Found = true
+		entry.URL = largerImage.URL
+		entry.Width = largerImage.Width
+		entry.Height = largerImage.Height
+		entry.Provider = largerImage.Provider
+		entry.SourcePage = largerImage.SourcePage
+		entry.Score = largerImage.Score
+	case !isNegative(err):
+		// the search failed, there is nothing to remember
+		return nil, err
+	}
+
+	if cacheErr := cache.Put(hash, entry); cacheErr != nil {
+		log.Errorf("[%s] error saving to cache: %s", filename, cacheErr)
+	}
+	return largerImage, err
+}
+
+// maxScoredResults is how many image results are downloaded to pick the best quality one from
+const maxScoredResults = 5
+
+// searchLargerImage does the google search for FindLargerImageFromFile, it also
+// returns the urls of all the candidate images it looked at.
+func searchLargerImage(filename string) (*ImageData, []string, error) {
+	var candidates []string
 
 	log.Tracef("[%s] Get Image Config for original file", filename)
 	originalImage, err := GetImageConfigFromFile(filename)
 	if err != nil {
-		return nil, fmt.Errorf("error from GetImageConfigFromFile: %w", err)
+		return nil, candidates, fmt.Errorf("error from GetImageConfigFromFile: %w", err)
 	}
 	log.Tracef("[%s] Got Image Config for original file", filename)
 
 	log.Tracef("[%s] Upload original file", filename)
 	redirectHTML, err := uploadImage(filename)
 	if err != nil {
-		return nil, fmt.Errorf("error from uploadImage: %w", err)
+		return nil, candidates, fmt.Errorf("error from uploadImage: %w", err)
 	}
 	log.Tracef("[%s] Uploaded original file", filename)
 
 	log.Tracef("[%s] Getting redirect url", filename)
 	redirectURL, err := getURLFromUploadResponse(redirectHTML)
 	if err != nil {
-		return nil, fmt.Errorf("error from getURLFromUploadResponse: %w", err)
+		return nil, candidates, fmt.Errorf("error from getURLFromUploadResponse: %w", err)
 	}
 	log.Tracef("[%s] Got redirect url: %s", filename, redirectURL)
 
 	log.Tracef("[%s] Getting image source url", filename)
 	foundURL, err := scrape(redirectURL.String(), findImageSourceLinkInHtml)
 	if err != nil {
-		return nil, fmt.Errorf("error from scrape found url: %w", err)
+		return nil, candidates, fmt.Errorf("error from scrape found url: %w", err)
 	}
 	log.Tracef("[%s] Got image source url: %s", filename, foundURL)
 
 	log.Tracef("[%s] Getting all sizes url", filename)
 	allSizesURL, err := scrape(foundURL.String(), findAllSizesLinkInHtml)
 	if err != nil {
-		return nil, fmt.Errorf("error from scrape all sizes: %w", err)
+		return nil, candidates, fmt.Errorf("error from scrape all sizes: %w", err)
 	}
 	log.Tracef("[%s] Got all sizes url: %s", filename, allSizesURL)
 
-	log.Tracef("[%s] Getting largest image url", filename)
-	largestImageURL, err := scrape(allSizesURL.String(), findLargestImageLinkInHtml)
+	log.Tracef("[%s] Getting image results", filename)
+	results, err := scrape(allSizesURL.String(), findImageResultsInHtml)
 	if err != nil {
-		return nil, fmt.Errorf("error from scrape largest image: %w", err)
+		return nil, candidates, fmt.Errorf("error from scrape image results: %w", err)
 	}
-	log.Tracef("[%s] Got largest image url: %s", filename, largestImageURL)
+	log.Tracef("[%s] Got %d image results", filename, len(results))
 
-	log.Tracef("[%s] Downloading largest image", filename)
-	largerImage, err := getImage(largestImageURL.String())
-	if err != nil {
-		return nil, fmt.Errorf("error from getImage: %w", err)
+	// the first result is the best match, it's all that's needed for the largest image. Other results
+	// are often upscales of it, so for the best quality the larger ones are all downloaded and scored.
+	var limit = 1
+	if selection == SelectBestQuality {
+		limit = maxScoredResults
 	}
-	log.Tracef("[%s] Downloaded largest image", filename)
+	var images []*ImageData
+	var firstErr error
+	for i, result := range results {
+		if len(images) == limit {
+			break
+		}
+		if i > 0 && result.Width*result.Height <= originalImage.Width*originalImage.Height {
+			continue
+		}
+		candidates = append(candidates, result.URL)
 
-	if largerImage.Area > originalImage.Width*originalImage.Height {
+		log.Tracef("[%s] Downloading image result: %s", filename, result.URL)
+		var resultImage, err = getImage(result.URL)
+		if err != nil {
+			log.Tracef("[%s] Error downloading image result: %s", filename, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		resultImage.Provider = "google"
+		resultImage.SourcePage = allSizesURL.String()
+		images = append(images, resultImage)
+	}
+	if len(images) == 0 {
+		return nil, candidates, fmt.Errorf("error from getImage: %w", firstErr)
+	}
+	log.Tracef("[%s] Downloaded %d image results", filename, len(images))
+
+	// google often found a resized copy on a cdn, see if the cdn has a bigger one
+	if variant, err := FindLargestVariant(images[0].URL, images[0].Area); err == nil {
+		if variantImage, err := downloadImage(variant.URL); err == nil {
+			log.Tracef("[%s] Larger %s variant of largest image: %s", filename, variant.Provider, variant.URL)
+			variantImage.Provider = "google/" + variant.Provider
+			variantImage.SourcePage = images[0].SourcePage
+			candidates = append(candidates, variant.URL)
+			images = append(images, variantImage)
+		}
+	}
+
+	var larger []*ImageData
+	for _, img := range images {
+		if img.Area > originalImage.Width*originalImage.Height {
+			larger = append(larger, img)
+		}
+	}
+	if len(larger) == 0 {
 		log.Tracef("[%s] Larger image not found", filename)
-		return largerImage, nil
+		return nil, candidates, ErrNoLargerAvailable
 	}
-	log.Tracef("[%s] Larger image found", filename)
 
-	return nil, ErrNoLargerAvailable
+	log.Tracef("[%s] Larger image found", filename)
+	return SelectImage(selection, larger...), candidates, nil
 }
 
 // GetLargerImageFromFile is just like FindLargerImageFromFile except it also downloads the file.
@@ -83,11 +225,27 @@ func GetLargerImageFromFile(filename, outputDir string) (*ImageData, error) {
 	if err != nil {
 		return nil, err
 	}
+	imageInfo.Provider = largerImage.Provider
+	if imageInfo.SourcePage == "" {
+		imageInfo.SourcePage = largerImage.SourcePage
+	}
+
+	// the file size is only known now
+	if err := checkThresholds(filename, imageInfo); err != nil {
+		return nil, err
+	}
+
+	return saveImage(imageInfo, outputDir)
+}
+
+// saveImage writes a downloaded image to outputDir, known error images are thrown away.
+// The native resolution of the image is estimated if the thresholds have not done it already.
+func saveImage(imageInfo *ImageData, outputDir string) (*ImageData, error) {
 	// some file names are crazy long and cant be a named FS file
 	var largerImageName = cleanURL(path.Base(imageInfo.URL), imageInfo.Extension)
 
-	var newFile = filepath.Join(outputDir, largerImageName)
-	if err := os.WriteFile(newFile, imageInfo.Bytes, os.ModePerm); err != nil {
+	newFile, err := writeUnique(outputDir, largerImageName, imageInfo.Bytes)
+	if err != nil {
 		return nil, err
 	}
 	imageInfo.LocalPath = newFile
@@ -104,51 +262,152 @@ func GetLargerImageFromFile(filename, outputDir string) (*ImageData, error) {
 		}
 		return nil, ErrNoLargerAvailable
 	}
+
+	// formats without a decoder, like avif, have no native resolution
+	if imageInfo.NativeWidth == 0 {
+		if err := EstimateNativeResolution(imageInfo); err != nil {
+			log.Tracef("[%s] Native resolution not estimated: %s", imageInfo.URL, err)
+		}
+	}
 	return imageInfo, nil
 }
 
-// FindLargerImageFromBytes takes a bytes and returns information about
-// a larger image that was found. It does NOT download the image.
-func FindLargerImageFromBytes(image []byte, outputFile string) (*ImageData, error) {
-	var tmpfile = "FindLargerImageFromBytesTmpfile.image"
-	defer os.Remove(tmpfile)
+// FindLargerImageFromURL takes the url of an image, usually a thumbnail found on a web page,
+// and returns information about a larger image that was found. The larger sizes the site
+// and its cdn serve are tried first and google is only searched when they are no bigger.
+// It does NOT write the image to disk.
+func FindLargerImageFromURL(link string) (*ImageData, error) {
 
-	var err = os.WriteFile(tmpfile, image, 0600)
+	log.Tracef("[%s] Downloading original image", link)
+	originalImage, err := downloadImage(link)
+	if err != nil {
+		return nil, fmt.Errorf("error from downloadImage: %w", err)
+	}
+	log.Tracef("[%s] Downloaded original image", link)
+
+	log.Tracef("[%s] Probing larger variants", link)
+	if variant, err := FindLargestVariant(link, originalImage.Area); err == nil {
+		largerImage, err := downloadImage(variant.URL)
+		if err == nil {
+			err = thresholds.Check(originalImage, largerImage)
+		}
+		if err == nil {
+			largerImage.Provider = variant.Provider
+			log.Tracef("[%s] Larger image found by %s: %s", link, largerImage.Provider, largerImage.URL)
+			if selection != SelectBestQuality {
+				return largerImage, nil
+			}
+
+			// the largest copy the site has may still be worse than one google finds elsewhere
+			searchedImage, err := FindLargerImageFromBytes(originalImage.Bytes, "")
+			if err != nil {
+				log.Tracef("[%s] Searching google failed: %s", link, err)
+				return largerImage, nil
+			}
+			return SelectImage(selection, largerImage, searchedImage), nil
+		}
+		log.Tracef("[%s] Variant %s not used: %s", link, variant.URL, err)
+	}
+
+	log.Tracef("[%s] Searching google", link)
+	return FindLargerImageFromBytes(originalImage.Bytes, "")
+}
+
+// GetLargerImageFromURL is just like FindLargerImageFromURL except it also writes the image to outputDir.
+func GetLargerImageFromURL(link, outputDir string) (*ImageData, error) {
+	var largerImage, err = FindLargerImageFromURL(link)
 	if err != nil {
 		return nil, err
 	}
 
-	largerImage, err := FindLargerImageFromFile(tmpfile)
+	return saveImage(largerImage, outputDir)
+}
+
+// FindLargerImageFromBytes takes a bytes and returns information about
+// a larger image that was found. It does NOT download the image.
+func FindLargerImageFromBytes(image []byte, outputFile string) (*ImageData, error) {
+	var tmpfile, err = writeTempImage(image)
 	if err != nil {
 		return nil, err
 	}
+	defer os.Remove(tmpfile)
 
-	return largerImage, os.Remove(tmpfile)
+	return FindLargerImageFromFile(tmpfile)
 }
 
 // GetLargerImageFromBytes is just like FindLargerImageFromBytes except it also downloads the file.
 func GetLargerImageFromBytes(image []byte, outputDir string) (*ImageData, error) {
-	var tmpfile = "GetLargerImageFromBytesTmpfile.image"
-	defer os.Remove(tmpfile)
-
-	var err = os.WriteFile(tmpfile, image, 0600)
+	var tmpfile, err = writeTempImage(image)
 	if err != nil {
 		return nil, err
 	}
+	defer os.Remove(tmpfile)
+
 	return GetLargerImageFromFile(tmpfile, outputDir)
 }
 
-func cleanURL(link, ext string) string {
+// writeUnique writes data to name in dir, or to name-1, name-2 ... if another
+// search already put a file with that name there.
+func writeUnique(dir, name string, data []byte) (string, error) {
+	var ext = filepath.Ext(name)
+	var base = strings.TrimSuffix(name, ext)
 
-	var re = regexp.MustCompile(`[^\w]`)
+	for i := 0; ; i++ {
+		var filename = filepath.Join(dir, name)
+		if i > 0 {
+			filename = filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, i, ext))
+		}
+
+		var file, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.ModePerm)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
 
-	var largerImageName = re.ReplaceAllString(link, "")
+		if _, err := file.Write(data); err != nil {
+			file.Close()
+			return "", err
+		}
+		return filename, file.Close()
+	}
+}
+
+// writeTempImage writes image to a uniquely named temp file so several searches can run at once.
+func writeTempImage(image []byte) (string, error) {
+	var file, err = os.CreateTemp("", "imageupsizer-*.image")
+	if err != nil {
+		return "", fmt.Errorf("error creating temp file: %w", err)
+	}
+
+	if _, err := file.Write(image); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("error writing temp file: %s, error: %w", file.Name(), err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("error closing temp file: %s, error: %w", file.Name(), err)
+	}
+	return file.Name(), nil
+}
+
+var nonWordRegex = regexp.MustCompile(`[^\w]`)
+
+// cleanURL makes a safe file name out of the last part of a url
+func cleanURL(link, ext string) string {
+
+	var largerImageName = nonWordRegex.ReplaceAllString(link, "")
+	ext = nonWordRegex.ReplaceAllString(ext, "")
 
 	if len(largerImageName) > 100 {
-		largerImageName = largerImageName[:100] + "." + ext
-	} else {
-		largerImageName = largerImageName + "." + ext
+		largerImageName = largerImageName[:100]
+	} else if largerImageName == "" {
+		largerImageName = "image"
 	}
 
-	return largerImageName
+	if ext == "" {
+		return largerImageName
+	}
+	return largerImageName + "." + ext
 }
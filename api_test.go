@@ -0,0 +1,127 @@
+package imageupsizer
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanURL(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "Sunflower_sky_backdropjpg.jpeg", cleanURL("Sunflower_sky_backdrop.jpg", "jpeg"))
+	assert.Equal(t, strings.Repeat("a", 100)+".png", cleanURL(strings.Repeat("a", 150), "png"))
+	assert.Equal(t, "image.jpeg", cleanURL("?=&", "jpeg"))
+}
+
+func FuzzCleanURL(f *testing.F) {
+	f.Add("Sunflower_sky_backdrop.jpg", "jpeg")
+	f.Add("images?q=tbn:ANd9GcQv6X9&usqp=CAU", "webp")
+	f.Add("", "")
+	f.Add("../../etc/passwd", "png")
+	f.Fuzz(func(t *testing.T, link, ext string) {
+		var name = cleanURL(link, ext)
+		if strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+			t.Fatalf("name escapes the output dir: %q", name)
+		}
+		if strings.HasPrefix(name, ".") {
+			t.Fatalf("name is hidden or empty: %q", name)
+		}
+	})
+}
+
+// encodePNG is a blank png of the given size
+func encodePNG(t *testing.T, size int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, size, size))))
+	return buf.Bytes()
+}
+
+// imageServer serves pngs of the given sizes by path, anything else is a 404
+func imageServer(t *testing.T, sizes map[string]int, upload http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" && upload != nil {
+			upload(w, r)
+			return
+		}
+		var size, ok = sizes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(encodePNG(t, size))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFindLargerImageFromURLVariant(t *testing.T) {
+	t.Parallel()
+
+	// the shop serves the thumbnail and, without the size suffix, the original
+	var server = imageServer(t, map[string]int{"/cdn/shop/files/sunflower_16x.png": 16, "/cdn/shop/files/sunflower.png": 64}, nil)
+
+	var img, err = FindLargerImageFromURL(server.URL + "/cdn/shop/files/sunflower_16x.png")
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/cdn/shop/files/sunflower.png", img.URL)
+	assert.Equal(t, "shopify", img.Provider)
+	assert.Equal(t, 64, img.Width)
+	assert.Empty(t, img.LocalPath)
+
+	var dir = t.TempDir()
+	img, err = GetLargerImageFromURL(server.URL+"/cdn/shop/files/sunflower_16x.png", dir)
+	require.NoError(t, err)
+	assert.Equal(t, dir, filepath.Dir(img.LocalPath))
+	saved, err := os.ReadFile(img.LocalPath)
+	require.NoError(t, err)
+	assert.Equal(t, encodePNG(t, 64), saved)
+}
+
+// not parallel, it points the google upload at the test server
+func TestFindLargerImageFromURLSearch(t *testing.T) {
+	var uploaded [][]byte
+	var server = imageServer(t, map[string]int{"/cdn/shop/files/sunflower_16x.png": 16}, func(w http.ResponseWriter, r *http.Request) {
+		var file, _, err = r.FormFile("encoded_image")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		assert.NoError(t, err)
+		uploaded = append(uploaded, data)
+		_, _ = w.Write([]byte(`<html><body>no results here</body></html>`))
+	})
+
+	var uploadURL = googleUploadURL
+	googleUploadURL = server.URL + "/upload"
+	t.Cleanup(func() { googleUploadURL = uploadURL })
+
+	// there is no larger size on the shop, so the thumbnail is searched for on google
+	var _, err = FindLargerImageFromURL(server.URL + "/cdn/shop/files/sunflower_16x.png")
+	assert.ErrorIs(t, err, ErrParse)
+	assert.ErrorContains(t, err, "getURLFromUploadResponse")
+
+	var dir = t.TempDir()
+	_, err = GetLargerImageFromURL(server.URL+"/cdn/shop/files/sunflower_16x.png", dir)
+	assert.ErrorIs(t, err, ErrParse)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	assert.Equal(t, [][]byte{encodePNG(t, 16), encodePNG(t, 16)}, uploaded)
+}
@@ -0,0 +1,57 @@
+package imageupsizer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeAtomic writes filename by handing write a temp file in the same directory, syncing it and
+// renaming it over filename, so filename is either untouched or complete. verify, when it's not nil,
+// checks the synced temp file before it is renamed.
+func writeAtomic(filename string, write func(*os.File) error, verify func(tmpfile string) error) error {
+	var dir = filepath.Dir(filename)
+	var tmp, err = os.CreateTemp(dir, "."+filepath.Base(filename)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temp file in: %s, error: %w", dir, err)
+	}
+	var tmpfile = tmp.Name()
+	// does nothing once the temp file was renamed
+	defer os.Remove(tmpfile)
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing temp file: %s, error: %w", tmpfile, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing temp file: %s, error: %w", tmpfile, err)
+	}
+
+	if verify != nil {
+		if err := verify(tmpfile); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpfile, filename); err != nil {
+		return fmt.Errorf("error renaming temp file: %s to: %s, error: %w", tmpfile, filename, err)
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	var d, err = os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("error opening dir: %s, error: %w", dir, err)
+	}
+	defer d.Close()
+
+	// not every platform can sync a directory, the rename is still atomic without it
+	_ = d.Sync()
+	return nil
+}
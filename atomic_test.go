@@ -0,0 +1,59 @@
+package imageupsizer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteAtomic(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var filename = filepath.Join(dir, "image.jpg")
+	assert.NoError(t, os.WriteFile(filename, []byte("old"), 0600))
+
+	var write = func(data string) func(*os.File) error {
+		return func(file *os.File) error {
+			_, err := file.WriteString(data)
+			return err
+		}
+	}
+	var errFailed = errors.New("failed")
+
+	// a failed write or verify leaves the old file and no temp file behind
+	assert.ErrorIs(t, writeAtomic(filename, func(*os.File) error { return errFailed }, nil), errFailed)
+	assert.ErrorIs(t, writeAtomic(filename, write("new"), func(string) error { return errFailed }), errFailed)
+	assert.Equal(t, "old", string(readFile(t, filename)))
+
+	assert.NoError(t, writeAtomic(filename, write("new"), nil))
+	assert.Equal(t, "new", string(readFile(t, filename)))
+
+	var entries, err = os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestConvertKeepOriginal(t *testing.T) {
+	t.Parallel()
+
+	var filename = writePNG(t, testImage(false))
+	var options = DefaultConvertOptions
+	options.KeepOriginal = true
+
+	var result, err = ConvertWithOptions(filename, options)
+	assert.NoError(t, err)
+	assert.FileExists(t, filename)
+	assert.FileExists(t, result.File)
+	assert.NoError(t, verifyImage(result.File))
+
+	// a file that can't be decoded is left alone
+	var broken = filepath.Join(t.TempDir(), "broken.png")
+	assert.NoError(t, os.WriteFile(broken, []byte("not an image"), 0600))
+	_, err = ConvertWithOptions(broken, DefaultConvertOptions)
+	assert.Error(t, err)
+	assert.FileExists(t, broken)
+}
@@ -0,0 +1,156 @@
+package imageupsizer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var lookupsBucket = []byte("lookups")
+
+// Cache remembers the outcome of every search by the content hash of the original
+// image so the same image is not uploaded to google again on the next run.
+type Cache struct {
+	db *bolt.DB
+	// PositiveTTL is how long a found larger image is trusted
+	PositiveTTL time.Duration
+	// NegativeTTL is how long "no larger image" is trusted, new copies show up on the web so this is usually shorter
+	NegativeTTL time.Duration
+}
+
+// CacheEntry is the outcome of one search.
+type CacheEntry struct {
+	// Found is false for negative entries, searches that ended in ErrNoLargerAvailable
+	Found bool `json:"found"`
+	// Candidates are all the image urls that were considered in the order google listed them, best
+	// match first, with the larger cdn variant of the first one last when there is one
+	Candidates []string  `json:"candidates,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Width      int       `json:"width,omitempty"`
+	Height     int       `json:"height,omitempty"`
+	Provider   string    `json:"provider,omitempty"`
+	SourcePage string    `json:"source_page,omitempty"`
+	Time       time.Time `json:"time"`
+	// Score is the quality of the image, set when it was found with SelectBestQuality
+	Score *Score `json:"score,omitempty"`
+}
+
+// OpenCache opens or creates the cache file.
+func OpenCache(filename string, positiveTTL, negativeTTL time.Duration) (*Cache, error) {
+	var db, err = bolt.Open(filename, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening cache: %s, error: %w", filename, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(lookupsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating cache bucket: %s, error: %w", filename, err)
+	}
+
+	return &Cache{db: db, PositiveTTL: positiveTTL, NegativeTTL: negativeTTL}, nil
+}
+
+// Close closes the cache file.
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// Get returns the entry for the image hash if there is one and it has not expired.
+func (c *Cache) Get(hash string) (*CacheEntry, bool) {
+	var entry *CacheEntry
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		var value = tx.Bucket(lookupsBucket).Get([]byte(hash))
+		if value == nil {
+			return nil
+		}
+		entry = new(CacheEntry)
+		return json.Unmarshal(value, entry)
+	})
+	if entry == nil {
+		return nil, false
+	}
+
+	var ttl = c.NegativeTTL
+	if entry.Found {
+		ttl = c.PositiveTTL
+	}
+	if time.Since(entry.Time) > ttl {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Put stores the entry for the image hash, the time is set to now if it's empty.
+func (c *Cache) Put(hash string, entry CacheEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	var value, err = json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding cache entry: %w", err)
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(lookupsBucket).Put([]byte(hash), value)
+	})
+}
+
+// Prune deletes every expired entry and returns how many were removed.
+func (c *Cache) Prune() (int, error) {
+	var removed int
+	var err = c.db.Update(func(tx *bolt.Tx) error {
+		var bucket = tx.Bucket(lookupsBucket)
+
+		// deleting while iterating skips keys, so collect them first
+		var expired [][]byte
+		err := bucket.ForEach(func(key, value []byte) error {
+			var entry CacheEntry
+			var ttl = c.NegativeTTL
+			if json.Unmarshal(value, &entry) == nil && entry.Found {
+				ttl = c.PositiveTTL
+			}
+			if time.Since(entry.Time) > ttl {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	return removed, err
+}
+
+// cache is used by FindLargerImageFromFile when it's set
+var cache *Cache
+
+// SetCache makes every search check c first and record its outcome in it, nil turns caching off.
+// It is not safe to call while searches are in flight.
+func SetCache(c *Cache) {
+	cache = c
+}
+
+// hashFile is the content hash the cache is keyed by
+func hashFile(filename string) (string, error) {
+	var ch = newHasher()
+	var hash, err = ch.HashFile(context.Background(), filename)
+	if err != nil {
+		return "", fmt.Errorf("error hashing file: %s, error: %w", filename, err)
+	}
+	return hash, nil
+}
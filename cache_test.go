@@ -0,0 +1,45 @@
+package imageupsizer
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	var cache, err = OpenCache(filepath.Join(t.TempDir(), "cache.db"), time.Hour, time.Minute)
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	_, ok := cache.Get("missing")
+	assert.False(t, ok)
+
+	assert.NoError(t, cache.Put("found", CacheEntry{Found: true, URL: "https://example.com/large.jpg", Width: 4000, Height: 3000, Provider: "google", Score: &Score{Area: 12000000, JPEGQuality: 90, Total: 9000000}}))
+	assert.NoError(t, cache.Put("not-found", CacheEntry{Candidates: []string{"https://example.com/small.jpg"}}))
+	assert.NoError(t, cache.Put("stale-negative", CacheEntry{Time: time.Now().Add(-2 * time.Minute)}))
+	assert.NoError(t, cache.Put("stale-positive", CacheEntry{Found: true, Time: time.Now().Add(-2 * time.Hour)}))
+
+	entry, ok := cache.Get("found")
+	assert.True(t, ok)
+	assert.Equal(t, "https://example.com/large.jpg", entry.URL)
+	assert.Equal(t, 4000, entry.Width)
+	assert.Equal(t, &Score{Area: 12000000, JPEGQuality: 90, Total: 9000000}, entry.Score)
+
+	entry, ok = cache.Get("not-found")
+	assert.True(t, ok)
+	assert.False(t, entry.Found)
+	assert.Equal(t, []string{"https://example.com/small.jpg"}, entry.Candidates)
+
+	_, ok = cache.Get("stale-negative")
+	assert.False(t, ok)
+	_, ok = cache.Get("stale-positive")
+	assert.False(t, ok)
+
+	removed, err := cache.Prune()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+}
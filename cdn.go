@@ -0,0 +1,332 @@
+package imageupsizer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cdnRewriters undo the resizing done by image cdns. Unlike site resolvers every
+// rewriter that matches a url is used, the same cdn can be behind any host name.
+var cdnRewriters = []Resolver{
+	wordpressRewriter,
+	shopifyRewriter,
+	cloudinaryRewriter,
+	imgixRewriter,
+	googleRewriter,
+	sizeQueryRewriter,
+}
+
+// wordpress adds -WIDTHxHEIGHT to every resized copy, and -scaled to the copy it makes of very large uploads
+var wordpressSizeRegex = regexp.MustCompile(`^(.+?)(-\d+x\d+|-scaled)\.(jpe?g|png|gif|webp)$`)
+
+var wordpressRewriter = Resolver{
+	Name: "wordpress",
+	Match: func(u *url.URL) bool {
+		return wordpressSizeRegex.MatchString(u.Path)
+	},
+	Rewrite: func(u *url.URL) []*url.URL {
+		var match = wordpressSizeRegex.FindStringSubmatch(u.Path)
+		var rewritten = withPath(u, match[1]+"."+match[3])
+		// photon (i0.wp.com) takes the size from the query too
+		if !hostIs(u, "wp.com") {
+			rewritten.RawQuery = u.RawQuery
+		}
+		return []*url.URL{rewritten}
+	},
+}
+
+// shopify sizes are a suffix on the file name: _600x, _x600, _600x400, _grande, _600x@2x, _600x400_crop_center
+var shopifySizeRegex = regexp.MustCompile(`^(.+?)_(\d+x\d*|x\d+|pico|icon|thumb|small|compact|medium|large|grande|1024x1024|2048x2048|master)(@\dx)?(_crop_\w+)?\.(jpe?g|png|gif|webp)$`)
+
+var shopifyRewriter = Resolver{
+	Name: "shopify",
+	Match: func(u *url.URL) bool {
+		return hostIs(u, "shopify.com") || strings.Contains(u.Path, "/cdn/shop/")
+	},
+	Rewrite: func(u *url.URL) []*url.URL {
+		var p = u.Path
+		if match := shopifySizeRegex.FindStringSubmatch(p); match != nil {
+			p = match[1] + "." + match[5]
+		}
+
+		// newer shop urls size with ?width= instead
+		var query = u.Query()
+		for _, key := range []string{"width", "height", "crop"} {
+			query.Del(key)
+		}
+
+		var rewritten = withPath(u, p)
+		rewritten.RawQuery = query.Encode()
+		if rewritten.String() == u.String() {
+			return nil
+		}
+		return []*url.URL{rewritten}
+	},
+}
+
+// cloudinary transformations are path segments like w_300,h_200,c_fill between /upload/ and the image id
+var cloudinaryTransformRegex = regexp.MustCompile(`^[a-z]{1,3}_[^/]+$`)
+
+var cloudinaryRewriter = Resolver{
+	Name: "cloudinary",
+	Match: func(u *url.URL) bool {
+		return hostIs(u, "cloudinary.com") || strings.Contains(u.Path, "/image/upload/")
+	},
+	Rewrite: func(u *url.URL) []*url.URL {
+		var segments = strings.Split(u.Path, "/")
+		var kept = make([]string, 0, len(segments))
+		var afterUpload, removed bool
+		for _, segment := range segments {
+			if afterUpload && cloudinaryTransformRegex.MatchString(segment) {
+				removed = true
+				continue
+			}
+			afterUpload = afterUpload || segment == "upload"
+			kept = append(kept, segment)
+		}
+		if !removed {
+			return nil
+		}
+		return []*url.URL{withPath(u, strings.Join(kept, "/"))}
+	},
+}
+
+// imgixParams are the imgix parameters that make an image smaller or lower quality
+var imgixParams = []string{"w", "h", "width", "height", "max-w", "max-h", "fit", "crop", "dpr", "q", "auto", "rect"}
+
+var imgixRewriter = Resolver{
+	Name: "imgix",
+	Match: func(u *url.URL) bool {
+		return hostIs(u, "imgix.net") || u.Query().Has("ixlib")
+	},
+	Rewrite: func(u *url.URL) []*url.URL {
+		var query = u.Query()
+		var removed bool
+		for _, key := range imgixParams {
+			if query.Has(key) {
+				query.Del(key)
+				removed = true
+			}
+		}
+		if !removed {
+			return nil
+		}
+		var rewritten = withPath(u, u.Path)
+		rewritten.RawQuery = query.Encode()
+		return []*url.URL{rewritten}
+	},
+}
+
+// google image hosts take the size at the end of the path, =s400, =w400-h300-c, or as a /s400/ segment
+var googleSizeSuffixRegex = regexp.MustCompile(`=[swh]\d+[^/]*$`)
+var googleSizeSegmentRegex = regexp.MustCompile(`/[swh]\d+(-[\w-]+)?/`)
+
+var googleRewriter = Resolver{
+	Name: "google",
+	Match: func(u *url.URL) bool {
+		return hostIs(u, "googleusercontent.com", "ggpht.com", "blogspot.com")
+	},
+	Rewrite: func(u *url.URL) []*url.URL {
+		var p = u.Path
+		switch {
+		case googleSizeSuffixRegex.MatchString(p):
+			p = googleSizeSuffixRegex.ReplaceAllString(p, "=s0")
+		case googleSizeSegmentRegex.MatchString(p):
+			p = googleSizeSegmentRegex.ReplaceAllString(p, "/s0/")
+		default:
+			p += "=s0"
+		}
+		if p == u.Path {
+			return nil
+		}
+		var rewritten = withPath(u, p)
+		rewritten.RawQuery = u.RawQuery
+		return []*url.URL{rewritten}
+	},
+}
+
+// sizeQueryParams are the query parameters sites commonly resize with
+var sizeQueryParams = []string{"w", "h", "width", "height", "resize", "size", "fit", "quality", "q"}
+
+// sizeQueryRewriter is the catch all for sites that resize with the query string, it simply drops the size
+var sizeQueryRewriter = Resolver{
+	Name: "query",
+	Match: func(u *url.URL) bool {
+		var query = u.Query()
+		for _, key := range sizeQueryParams {
+			if query.Has(key) {
+				return true
+			}
+		}
+		return false
+	},
+	Rewrite: func(u *url.URL) []*url.URL {
+		var query = u.Query()
+		for _, key := range sizeQueryParams {
+			query.Del(key)
+		}
+		var rewritten = withPath(u, u.Path)
+		rewritten.RawQuery = query.Encode()
+		return []*url.URL{rewritten}
+	},
+}
+
+// variant is a url that might serve a larger copy of an image and what suggested it
+type variant struct {
+	URL      *url.URL
+	Provider string
+}
+
+// variants returns every larger variant of u, the site's resolver first then the cdns.
+func variants(u *url.URL) []variant {
+	var seen = map[string]bool{u.String(): true}
+	var found []variant
+	var add = func(provider string, urls []*url.URL) {
+		for _, candidate := range urls {
+			if !seen[candidate.String()] {
+				seen[candidate.String()] = true
+				found = append(found, variant{URL: candidate, Provider: provider})
+			}
+		}
+	}
+
+	if r := resolverFor(u); r != nil && r.Rewrite != nil {
+		add(r.Name, r.Rewrite(u))
+	}
+	for _, r := range cdnRewriters {
+		if r.Match(u) {
+			add(r.Name, r.Rewrite(u))
+		}
+	}
+	return found
+}
+
+// FindLargestVariant rewrites link into the urls of the larger copies the site or its cdn
+// serves, probes each of them and returns the largest. Only the image header is downloaded
+// so the returned ImageData has no Bytes. ErrNoLargerAvailable is returned when no variant is larger than minArea.
+func FindLargestVariant(link string, minArea int) (*ImageData, error) {
+	var u, err = url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing url: %s, error: %w", link, err)
+	}
+
+	var largest *ImageData
+	for _, v := range variants(u) {
+		img, err := probeImage(v.URL.String())
+		if err != nil {
+			log.Tracef("[%s] %s variant %s failed: %s", link, v.Provider, v.URL, err)
+			continue
+		}
+		log.Tracef("[%s] %s variant %s is %dx%d", link, v.Provider, v.URL, img.Width, img.Height)
+
+		if img.Area > minArea && (largest == nil || img.Area > largest.Area) {
+			img.Provider = v.Provider
+			largest = img
+		}
+	}
+
+	if largest == nil {
+		return nil, ErrNoLargerAvailable
+	}
+	return largest, nil
+}
+
+// probeSize is how much of an image is read to find its dimensions, enough for the header of
+// every format we decode and for jpegs with a typical amount of exif in front of the frame.
+const probeSize = 64 * 1024
+
+var probeClient = &http.Client{
+	Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			//nolint:gosec
+			InsecureSkipVerify: true,
+		},
+	},
+}
+
+// probeImage finds the dimensions of a remote image without downloading all of it.
+// A HEAD request weeds out missing images and pages, then the start of the image is fetched with a range request.
+func probeImage(link string) (*ImageData, error) {
+	var req, err = http.NewRequest(http.MethodHead, link, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating http req, url: %s, error: %w", link, err)
+	}
+	req.Header.Add("User-Agent", userAgent)
+
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making http req, url: %s, error: %w", link, err)
+	}
+	resp.Body.Close()
+
+	// some servers don't implement HEAD, let the GET decide for them
+	if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, fmt.Errorf("non 2xx resp code: %d, url: %s", resp.StatusCode, link)
+		}
+		if contentType := resp.Header.Get("content-type"); contentType != "" && !strings.HasPrefix(contentType, "image/") {
+			return nil, fmt.Errorf("content type is %s, url: %s", contentType, link)
+		}
+	}
+
+	req, err = http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating http req, url: %s, error: %w", link, err)
+	}
+	req.Header.Add("User-Agent", userAgent)
+	req.Header.Add("Range", fmt.Sprintf("bytes=0-%d", probeSize-1))
+
+	resp, err = probeClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making http req, url: %s, error: %w", link, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("non 2xx resp code: %d, url: %s", resp.StatusCode, link)
+	}
+
+	// servers that ignore Range send the whole image, we still only want the start
+	head, err := io.ReadAll(io.LimitReader(resp.Body, probeSize))
+	if err != nil {
+		return nil, fmt.Errorf("error reading resp.Body, url: %s, error: %w", link, err)
+	}
+
+	config, ext, err := image.DecodeConfig(bytes.NewReader(head))
+	if err != nil {
+		// the header did not fit, fall back to downloading the whole thing
+		return downloadImage(link)
+	}
+
+	return &ImageData{
+		URL:       link,
+		Extension: ext,
+		Config:    config,
+		Area:      config.Width * config.Height,
+		FileSize:  contentLength(resp),
+	}, nil
+}
+
+// contentLength is the size of the whole file, even when resp is a partial response.
+func contentLength(resp *http.Response) int64 {
+	if resp.StatusCode == http.StatusPartialContent {
+		var contentRange = resp.Header.Get("Content-Range")
+		if idx := strings.LastIndex(contentRange, "/"); idx != -1 {
+			if size, err := strconv.ParseInt(contentRange[idx+1:], 10, 64); err == nil {
+				return size
+			}
+		}
+		return -1
+	}
+	return resp.ContentLength
+}
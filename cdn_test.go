@@ -0,0 +1,79 @@
+package imageupsizer
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVariants(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		in  string
+		out []string
+	}{
+		{"https://example.com/wp-content/uploads/2023/05/sunflower-300x200.jpg", []string{"https://example.com/wp-content/uploads/2023/05/sunflower.jpg"}},
+		{"https://example.com/wp-content/uploads/2023/05/sunflower-scaled.jpg", []string{"https://example.com/wp-content/uploads/2023/05/sunflower.jpg"}},
+		{"https://i0.wp.com/example.com/wp-content/uploads/sunflower-1024x683.jpg?resize=300%2C200", []string{"https://i0.wp.com/example.com/wp-content/uploads/sunflower.jpg", "https://i0.wp.com/example.com/wp-content/uploads/sunflower-1024x683.jpg"}},
+		{"https://cdn.shopify.com/s/files/1/0001/products/sunflower_600x.jpg?v=1612", []string{"https://cdn.shopify.com/s/files/1/0001/products/sunflower.jpg?v=1612"}},
+		{"https://shop.example.com/cdn/shop/products/sunflower.jpg?v=1612&width=600", []string{"https://shop.example.com/cdn/shop/products/sunflower.jpg?v=1612"}},
+		{"https://res.cloudinary.com/demo/image/upload/w_300,h_200,c_fill/q_auto/v1571218039/sunflower.jpg", []string{"https://res.cloudinary.com/demo/image/upload/v1571218039/sunflower.jpg"}},
+		{"https://assets.imgix.net/sunflower.jpg?w=400&h=300&fit=crop&ixlib=js-2.3.1", []string{"https://assets.imgix.net/sunflower.jpg?ixlib=js-2.3.1"}},
+		{"https://lh3.googleusercontent.com/a1b2c3d4=s400", []string{"https://lh3.googleusercontent.com/a1b2c3d4=s0"}},
+		{"https://lh3.googleusercontent.com/a1b2c3d4=w400-h300-c", []string{"https://lh3.googleusercontent.com/a1b2c3d4=s0"}},
+		{"https://1.bp.blogspot.com/-abc/XYZ/AAA/def/s320/sunflower.jpg", []string{"https://1.bp.blogspot.com/-abc/XYZ/AAA/def/s0/sunflower.jpg"}},
+		{"https://i.pinimg.com/236x/8f/3a/2b/8f3a2b6f.jpg", []string{"https://i.pinimg.com/originals/8f/3a/2b/8f3a2b6f.jpg", "https://i.pinimg.com/736x/8f/3a/2b/8f3a2b6f.jpg"}},
+		{"https://example.com/images/sunflower.jpg", nil},
+	}
+
+	for _, test := range tests {
+		var in, err = url.Parse(test.in)
+		assert.NoError(t, err)
+
+		var out []string
+		for _, v := range variants(in) {
+			out = append(out, v.URL.String())
+		}
+		assert.Equal(t, test.out, out, test.in)
+	}
+}
+
+func TestProbeImage(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 640, 480)), nil))
+
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.jpg":
+			http.ServeContent(w, r, "image.jpg", time.Time{}, bytes.NewReader(buf.Bytes()))
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var img, err = probeImage(server.URL + "/image.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, 640*480, img.Area)
+	assert.Equal(t, int64(buf.Len()), img.FileSize)
+	assert.Equal(t, "jpeg", img.Extension)
+
+	_, err = probeImage(server.URL + "/page")
+	assert.Error(t, err)
+
+	_, err = probeImage(server.URL + "/missing.jpg")
+	assert.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// file statuses recorded in the journal
+const (
+	statusPending  = "pending"
+	statusDone     = "done"
+	statusNoLarger = "no-larger"
+	// statusRejected means a larger image was found but the thresholds rejected it,
+	// unlike statusNoLarger it is tried again on -resume as the thresholds may have changed
+	statusRejected = "rejected"
+	statusFailed   = "failed"
+	// statusSkipped means the output existed and -collision is skip
+	statusSkipped = "skipped"
+	// statusUndone means undo put the original back
+	statusUndone = "undone"
+)
+
+// journalEntry is one line of the journal, the last line for a path is its current status
+type journalEntry struct {
+	Path       string `json:"path"`
+	Status     string `json:"status"`
+	ErrorClass string `json:"error_class,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Output     string `json:"output,omitempty"`
+	// Backup is where -in-place moved the original to
+	Backup string `json:"backup,omitempty"`
+	// Sidecar is the provenance file written next to Output, undo removes it with Output
+	Sidecar string    `json:"sidecar,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// journal is an append only log of the status of every file in a run. Each entry
+// is synced to disk as it's written so a crash loses at most the file being worked on.
+type journal struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries map[string]journalEntry
+	order   []string
+}
+
+// openJournal opens the journal, when resume is false it's truncated and a new run is started.
+// A readOnly journal is only loaded, it must not be written to.
+func openJournal(filename string, resume, readOnly bool) (*journal, error) {
+	var j = &journal{entries: make(map[string]journalEntry)}
+
+	var flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resume {
+		if err := j.load(filename); err != nil {
+			return nil, err
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+	if readOnly {
+		return j, nil
+	}
+
+	var file, err = os.OpenFile(filename, flags, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %s, error: %w", filename, err)
+	}
+	j.file = file
+	return j, nil
+}
+
+// load replays an existing journal, a missing file is an empty journal.
+func (j *journal) load(filename string) error {
+	var file, err = os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error opening journal: %s, error: %w", filename, err)
+	}
+	defer file.Close()
+
+	var scanner = bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		// a crash can leave half a line at the end, it's the file that was being worked on so it's still pending
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Path == "" {
+			continue
+		}
+		j.set(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading journal: %s, error: %w", filename, err)
+	}
+	return nil
+}
+
+func (j *journal) set(entry journalEntry) {
+	if _, exists := j.entries[entry.Path]; !exists {
+		j.order = append(j.order, entry.Path)
+	}
+	j.entries[entry.Path] = entry
+}
+
+// record appends the entry to the journal and syncs it to disk.
+func (j *journal) record(entry journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	var line, err = json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding journal entry: %w", err)
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing journal: %w", err)
+	}
+
+	j.set(entry)
+	return nil
+}
+
+// pending records every path that is not in the journal yet as pending, with a single sync.
+func (j *journal) pending(paths []string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var buf []byte
+	var now = time.Now()
+	for _, path := range paths {
+		if _, exists := j.entries[path]; exists {
+			continue
+		}
+		var entry = journalEntry{Path: path, Status: statusPending, Time: now}
+		var line, err = json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("error encoding journal entry: %w", err)
+		}
+		buf = append(append(buf, line...), '\n')
+		j.set(entry)
+	}
+
+	if _, err := j.file.Write(buf); err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	return j.file.Sync()
+}
+
+// status returns the last recorded entry for path.
+func (j *journal) status(path string) (journalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var entry, ok = j.entries[path]
+	return entry, ok
+}
+
+// withStatus returns every path whose last entry has the given status, in the order they were first recorded.
+func (j *journal) withStatus(status string) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var paths []string
+	for _, path := range j.order {
+		if j.entries[path].Status == status {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func (j *journal) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
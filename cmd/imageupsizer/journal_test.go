@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kmulvey/imageupsizer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotAvailableStatus(t *testing.T) {
+	t.Parallel()
+
+	var rejected = imageupsizer.Thresholds{MinWidth: 4000}.Check(&imageupsizer.ImageData{}, &imageupsizer.ImageData{Config: image.Config{Width: 2000, Height: 1000}})
+	require.Error(t, rejected)
+	assert.True(t, notAvailable(rejected))
+	assert.Equal(t, statusRejected, notAvailableStatus(fmt.Errorf("GetLargerImageFromFile: %w", rejected)))
+	assert.True(t, notAvailable(imageupsizer.ErrNoLargerAvailable))
+	assert.Equal(t, statusNoLarger, notAvailableStatus(imageupsizer.ErrNoLargerAvailable))
+}
+
+func TestJournal(t *testing.T) {
+	t.Parallel()
+
+	var filename = filepath.Join(t.TempDir(), "journal.jsonl")
+
+	// a read only journal that does not exist is empty and is not created
+	j, err := openJournal(filename, true, true)
+	require.NoError(t, err)
+	assert.Empty(t, j.withStatus(statusPending))
+	assert.NoFileExists(t, filename)
+
+	j, err = openJournal(filename, false, false)
+	require.NoError(t, err)
+	var files = []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg", "r.jpg"}
+	require.NoError(t, j.pending(files))
+	require.NoError(t, j.record(journalEntry{Path: "a.jpg", Status: statusDone, Output: "out/a.jpg"}))
+	require.NoError(t, j.record(journalEntry{Path: "b.jpg", Status: statusNoLarger}))
+	require.NoError(t, j.record(journalEntry{Path: "c.jpg", Status: statusFailed, ErrorClass: "network", Error: "timeout"}))
+	require.NoError(t, j.record(journalEntry{Path: "d.jpg", Status: statusSkipped}))
+	require.NoError(t, j.record(journalEntry{Path: "r.jpg", Status: statusRejected, ErrorClass: "no-larger"}))
+	require.NoError(t, j.Close())
+
+	// a crash in the middle of the next line leaves half of it
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"path":"e.jpg","sta`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	// the last entry of each path is its status, in the order the paths were first seen
+	j, err = openJournal(filename, true, false)
+	require.NoError(t, err)
+	var entry, ok = j.status("a.jpg")
+	assert.True(t, ok)
+	assert.Equal(t, statusDone, entry.Status)
+	assert.Equal(t, "out/a.jpg", entry.Output)
+	assert.False(t, entry.Time.IsZero())
+	entry, _ = j.status("c.jpg")
+	assert.Equal(t, "network", entry.ErrorClass)
+	assert.Equal(t, []string{"e.jpg"}, j.withStatus(statusPending))
+
+	// resume skips what is finished, rejected files are tried again with the new thresholds and new files are picked up
+	assert.Equal(t, []string{"c.jpg", "e.jpg", "r.jpg", "f.jpg"}, unfinished(j, append(files, "f.jpg")))
+	// retry-failed only takes the failed ones
+	assert.Equal(t, []string{"c.jpg"}, j.withStatus(statusFailed))
+
+	// pending does not reset the status of files already in the journal
+	require.NoError(t, j.pending(append(files, "f.jpg")))
+	assert.Equal(t, []string{"e.jpg", "f.jpg"}, j.withStatus(statusPending))
+	require.NoError(t, j.record(journalEntry{Path: "c.jpg", Status: statusDone}))
+	require.NoError(t, j.Close())
+
+	j, err = openJournal(filename, true, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.jpg", "c.jpg"}, j.withStatus(statusDone))
+	assert.Empty(t, j.withStatus(statusFailed))
+
+	// a new run starts from scratch
+	j, err = openJournal(filename, false, false)
+	require.NoError(t, err)
+	require.NoError(t, j.Close())
+	j, err = openJournal(filename, true, true)
+	require.NoError(t, err)
+	_, ok = j.status("a.jpg")
+	assert.False(t, ok)
+}
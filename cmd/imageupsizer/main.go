@@ -4,18 +4,18 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	_ "image/jpeg"
-	_ "image/png"
+	"image"
+	"image/color"
+	"io"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	_ "golang.org/x/image/webp"
-
 	"github.com/kmulvey/humantime"
 	"github.com/kmulvey/imageupsizer"
 	"github.com/kmulvey/path"
@@ -24,14 +24,73 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "undo" {
+		undo(os.Args[2:])
+		return
+	}
+
 	var inputEntry path.Entry
 	var outputEntry string
 	var logLevel string
+	var cookieFile string
+	var language, region string
+	var imageURL string
+	var cacheFile string
+	var cacheTTL, cacheNegativeTTL time.Duration
+	var journalFile string
+	var resume, retryFailed bool
+	var workers int
+	var limits imageupsizer.Limits
+	var dryRun bool
+	var reportFile string
+	var minGain, minResolution string
+	var maxSizeGrowth, maxUpscale float64
+	var selection string
+	var rejectUpscaled bool
+	var opts = outputOptions{convert: imageupsizer.DefaultConvertOptions}
+	var format, subsampling, alpha, background string
+	var metadata, sidecar, collision string
+	var backupRoot string
 	var tr humantime.TimeRange
 	flag.Var(&inputEntry, "input", "path to files, globbing must be quoted")
+	flag.StringVar(&imageURL, "url", "", "url of a single image to upsize instead of -input")
 	flag.StringVar(&outputEntry, "output", "./output", "A directory to put the larger image in")
 	flag.Var(&tr, "modified-since", "process files chnaged since this time")
 	flag.StringVar(&logLevel, "log-level", "error", "Set the level of log output: (info, warn, error)")
+	flag.StringVar(&cookieFile, "cookies", "", "Netscape cookies.txt file to load the google session from, it is updated when the run finishes")
+	flag.StringVar(&language, "lang", "en", "Language google should answer in, e.g. en, de, pt-BR")
+	flag.StringVar(&region, "region", "", "Two letter region google should search from, e.g. us, de")
+	flag.StringVar(&cacheFile, "cache", "", "file to cache search results in so images are not searched for again on the next run")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 30*24*time.Hour, "how long a cached larger image is used")
+	flag.DurationVar(&cacheNegativeTTL, "cache-negative-ttl", 7*24*time.Hour, "how long a cached \"no larger image\" is used")
+	flag.StringVar(&journalFile, "journal", "imageupsizer-journal.jsonl", "file to record the status of every file in, used by -resume and -retry-failed")
+	flag.BoolVar(&resume, "resume", false, "continue the run in -journal, files that are done or have no larger image are skipped, files whose larger image was rejected by the thresholds are tried again")
+	flag.BoolVar(&retryFailed, "retry-failed", false, "only reprocess the files that failed in -journal, -input is not needed")
+	flag.IntVar(&workers, "workers", 1, "number of files to upsize at the same time")
+	flag.IntVar(&limits.Browsers, "browsers", 2, "number of chrome instances the workers may run at the same time, 0 is no limit")
+	flag.DurationVar(&limits.UploadInterval, "upload-interval", time.Second, "least time between two uploads to google, 0 is no limit")
+	flag.StringVar(&reportFile, "report", "", "file to write a report of every file to, csv when it ends in .csv, json otherwise")
+	flag.StringVar(&minGain, "min-gain", "", "smallest area gain worth upsizing for, in pixels (50000) or percent of the original (20%)")
+	flag.StringVar(&minResolution, "min-resolution", "", "smallest resolution worth upsizing to in either orientation, e.g. 1920x1080")
+	flag.Float64Var(&maxSizeGrowth, "max-size-growth", 0, "reject images whose file size grows more than this many times faster than their area, 0 is no limit")
+	flag.Float64Var(&maxUpscale, "max-upscale", 0, "reject images more than this many times wider or taller than the original as suspicious, 0 is no limit")
+	flag.BoolVar(&rejectUpscaled, "reject-upscaled", false, "reject images that look like upscales with no more detail than the original")
+	flag.StringVar(&selection, "select", string(imageupsizer.SelectLargest), "how to pick between several larger images: largest or quality")
+	flag.StringVar(&format, "format", string(opts.convert.Format), "format to write larger images in: keep, jpeg or png")
+	flag.IntVar(&opts.convert.Quality, "quality", opts.convert.Quality, "jpeg quality, 1 to 100")
+	flag.StringVar(&subsampling, "subsampling", string(opts.convert.Subsampling), "allow jpeg chroma subsampling or avoid it by writing png instead")
+	flag.BoolVar(&opts.convert.KeepLossless, "keep-lossless", false, "never convert png and lossless webp images to jpeg")
+	flag.StringVar(&alpha, "alpha", string(opts.convert.Alpha), "what to do with transparent images when converting to jpeg: keep them as png or flatten them onto -background")
+	flag.StringVar(&background, "background", "#ffffff", "color transparent images are flattened onto, as #rrggbb")
+	flag.BoolVar(&opts.convert.KeepOriginal, "keep-original", false, "keep the downloaded image next to the converted one, named like it with the extension of the download")
+	flag.StringVar(&metadata, "metadata", string(imageupsizer.MetadataSafe), "metadata to copy from the original onto the larger image: all, safe (date, camera, gps, color profile, keywords and rating) or strip")
+	flag.StringVar(&opts.nameTemplate, "name", imageupsizer.DefaultNameTemplate, "where to put each larger image under -output, placeholders: {dir} {name} {ext} {origext} {hash} {width} {height}")
+	flag.StringVar(&collision, "collision", string(imageupsizer.CollisionSuffix), "what to do when the output file exists: skip, overwrite or suffix")
+	flag.BoolVar(&opts.inPlace, "in-place", false, "replace each original with its larger version instead of writing to -output, the originals are moved to -backup-dir")
+	flag.StringVar(&backupRoot, "backup-dir", "", "where -in-place keeps the originals, in a directory per run, defaults to .imageupsizer-backup in -input")
+	flag.StringVar(&sidecar, "sidecar", "json", "write where each larger image came from next to it, as json or xmp, none turns it off")
+	flag.BoolVar(&opts.embedProvenance, "embed-provenance", false, "also add where each larger image came from to its xmp")
+	flag.BoolVar(&dryRun, "dry-run", false, "only search and print what would be upsized, nothing is written to -output or the journal. The images found are still downloaded to measure them, and the -cache is updated")
 	flag.Parse()
 
 	switch strings.ToLower(logLevel) {
@@ -47,26 +106,155 @@ func main() {
 		flag.PrintDefaults()
 	}
 
-	var inputFiles, err = inputEntry.Flatten(false)
+	if !dryRun && !opts.inPlace {
+		if err := os.MkdirAll(outputEntry, os.ModePerm); err != nil {
+			log.Error("output path must be directory: ", outputEntry)
+			return
+		}
+	}
+
+	imageupsizer.SetLocale(imageupsizer.Locale{Language: language, Region: region})
+
+	var thresholds = imageupsizer.Thresholds{MaxSizeGrowthRatio: maxSizeGrowth, MaxUpscaleFactor: maxUpscale, RejectUpscaled: rejectUpscaled}
+	if err := parseGain(minGain, &thresholds); err != nil {
+		log.Fatal(err)
+	}
+	if err := parseResolution(minResolution, &thresholds); err != nil {
+		log.Fatal(err)
+	}
+	imageupsizer.SetThresholds(thresholds)
+	imageupsizer.SetLimits(limits)
+
+	switch imageupsizer.Selection(selection) {
+	case imageupsizer.SelectLargest, imageupsizer.SelectBestQuality:
+		imageupsizer.SetSelection(imageupsizer.Selection(selection))
+	default:
+		log.Fatalf("invalid -select: %s, must be largest or quality", selection)
+	}
+
+	switch opts.convert.Format = imageupsizer.Format(format); opts.convert.Format {
+	case imageupsizer.FormatKeep, imageupsizer.FormatJPEG, imageupsizer.FormatPNG:
+	default:
+		log.Fatalf("invalid -format: %s, must be keep, jpeg or png", format)
+	}
+	switch opts.convert.Subsampling = imageupsizer.Subsampling(subsampling); opts.convert.Subsampling {
+	case imageupsizer.SubsamplingAllow, imageupsizer.SubsamplingAvoid:
+	default:
+		log.Fatalf("invalid -subsampling: %s, must be allow or avoid", subsampling)
+	}
+	switch opts.convert.Alpha = imageupsizer.AlphaPolicy(alpha); opts.convert.Alpha {
+	case imageupsizer.AlphaKeep, imageupsizer.AlphaFlatten:
+	default:
+		log.Fatalf("invalid -alpha: %s, must be keep or flatten", alpha)
+	}
+	backgroundColor, err := parseColor(background)
 	if err != nil {
-		log.Fatalf("error flattening newFiles: %s", err)
+		log.Fatal(err)
+	}
+	opts.convert.Background = backgroundColor
+	if opts.convert.Quality < 1 || opts.convert.Quality > 100 {
+		log.Fatalf("invalid -quality: %d, must be 1 to 100", opts.convert.Quality)
+	}
+	switch opts.metadata = imageupsizer.MetadataPolicy(metadata); opts.metadata {
+	case imageupsizer.MetadataAll, imageupsizer.MetadataSafe, imageupsizer.MetadataStrip:
+	default:
+		log.Fatalf("invalid -metadata: %s, must be all, safe or strip", metadata)
+	}
+	if err := imageupsizer.ValidateNameTemplate(opts.nameTemplate); err != nil {
+		log.Fatalf("invalid -name: %s", err)
+	}
+	switch opts.collision = imageupsizer.CollisionPolicy(collision); opts.collision {
+	case imageupsizer.CollisionSkip, imageupsizer.CollisionOverwrite, imageupsizer.CollisionSuffix:
+	default:
+		log.Fatalf("invalid -collision: %s, must be skip, overwrite or suffix", collision)
+	}
+	switch opts.sidecar = imageupsizer.SidecarFormat(sidecar); opts.sidecar {
+	case "none":
+		opts.sidecar = ""
+	case imageupsizer.SidecarJSON, imageupsizer.SidecarXMP:
+	default:
+		log.Fatalf("invalid -sidecar: %s, must be json, xmp or none", sidecar)
 	}
 
-	if len(inputFiles) == 0 {
-		log.Error("path not provided")
-		flag.PrintDefaults()
-		return
+	if cookieFile != "" {
+		if err := imageupsizer.LoadCookies(cookieFile); err != nil {
+			log.Fatalf("error loading cookies: %s", err)
+		}
+		defer func() {
+			if err := imageupsizer.SaveCookies(cookieFile); err != nil {
+				log.Errorf("error saving cookies: %s", err)
+			}
+		}()
+	}
+
+	if cacheFile != "" {
+		cache, err := imageupsizer.OpenCache(cacheFile, cacheTTL, cacheNegativeTTL)
+		if err != nil {
+			log.Fatalf("error opening cache: %s", err)
+		}
+		defer cache.Close()
+		if removed, err := cache.Prune(); err != nil {
+			log.Errorf("error pruning cache: %s", err)
+		} else {
+			log.Infof("pruned %d expired cache entries", removed)
+		}
+		imageupsizer.SetCache(cache)
 	}
 
-	if err := os.MkdirAll(outputEntry, os.ModePerm); err != nil {
-		log.Error("output path must be directory: ", outputEntry)
+	if imageURL != "" {
+		if opts.inPlace {
+			log.Fatal("-in-place needs -input, there is no original to replace for -url")
+		}
+		var metadataSet bool
+		flag.Visit(func(f *flag.Flag) { metadataSet = metadataSet || f.Name == "metadata" })
+		if metadataSet {
+			log.Fatal("-metadata needs -input, there is no original file to copy it from for -url")
+		}
+		if dryRun {
+			findURL(imageURL)
+			return
+		}
+		upsizeURL(imageURL, outputEntry, opts)
 		return
 	}
 
-	log.Info("building file list")
-	var files = getFileList(inputEntry, tr)
+	// a dry run leaves the journal alone so the real run can still resume it
+	journal, err := openJournal(journalFile, resume || retryFailed, dryRun)
+	if err != nil {
+		log.Fatalf("error opening journal: %s", err)
+	}
+	defer journal.Close()
+
+	var files []string
+	if retryFailed {
+		files = journal.withStatus(statusFailed)
+	} else {
+		inputFiles, err := inputEntry.Flatten(false)
+		if err != nil {
+			log.Fatalf("error flattening newFiles: %s", err)
+		}
+
+		if len(inputFiles) == 0 {
+			log.Error("path not provided")
+			flag.PrintDefaults()
+			return
+		}
+
+		log.Info("building file list")
+		files = getFileList(inputEntry, tr)
+		opts.inputRoot = inputRoot(inputEntry)
+		if resume {
+			files = unfinished(journal, files)
+		}
+	}
 	log.Infof("upsizing %d files", len(files))
 
+	if !dryRun {
+		if err := journal.pending(files); err != nil {
+			log.Fatalf("error writing journal: %s", err)
+		}
+	}
+
 	var signals = make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
@@ -75,71 +263,547 @@ func main() {
 		"outputDir":      outputEntry,
 		"modified-since": tr.From,
 		"log-level":      logLevel,
+		"cookies":        cookieFile,
+		"lang":           language,
+		"region":         region,
+		"cache":          cacheFile,
+		"journal":        journalFile,
+		"resume":         resume,
+		"retry-failed":   retryFailed,
+		"workers":        workers,
+		"browsers":       limits.Browsers,
+		"dry-run":        dryRun,
+		"report":         reportFile,
 	}).Info("Started")
 
-	var warnings []logrus.Fields
-	for _, path := range files {
-		if len(signals) > 0 {
-			log.Info("shutting down")
-			break
-		}
-
-		largerImage, err := imageupsizer.GetLargerImageFromFile(path, outputEntry)
-		if err != nil {
-			if errors.Is(err, imageupsizer.ErrNoLargerAvailable) || errors.Is(err, imageupsizer.ErrNoResults) || errors.Is(err, imageupsizer.OtherSizesNotAvailableError) || errors.Is(err, imageupsizer.NoMatchesError) {
-				log.Tracef("[%s] Larger image not available", path)
-				continue // we just keep going
+	var counts = make(map[string]int)
+	var rows []reportRow
+	if reportFile != "" {
+		defer func() {
+			if err := writeReport(reportFile, rows); err != nil {
+				log.Errorf("error writing report: %s", err)
 			}
-			log.Errorf("GetLargerImageFromFile, %s, %v", path, err)
-			continue
+		}()
+	}
+
+	if dryRun {
+		var started = runPool(files, workers, signals, findFile, func(res result) {
+			counts[res.status]++
+			rows = append(rows, res.reportRow())
+			reportFound(os.Stdout, res)
+		})
+		fmt.Printf("would upsize: %d, no larger image: %d, rejected: %d, failed: %d, not started: %d\n", counts[statusDone], counts[statusNoLarger], counts[statusRejected], counts[statusFailed], len(files)-started)
+		return
+	}
+
+	// downloads wait here until they have their final name, hidden so it's not taken for output
+	opts.stagingDir = filepath.Join(outputEntry, ".imageupsizer-staging")
+	if opts.inPlace {
+		if backupRoot == "" && opts.inputRoot == "" {
+			log.Fatal("-in-place with -retry-failed needs -backup-dir or -input")
+		}
+		if backupRoot == "" {
+			backupRoot = filepath.Join(opts.inputRoot, ".imageupsizer-backup")
+		}
+		// the backups are recorded by their absolute path so undo works from any dir
+		if backupRoot, err = filepath.Abs(backupRoot); err != nil {
+			log.Fatalf("error resolving -backup-dir: %s", err)
+		}
+		// every run gets its own directory so a second run does not touch the backups of the first
+		if opts.backupDir, err = newRunDir(backupRoot, time.Now()); err != nil {
+			log.Fatalf("error creating backup dir: %s", err)
 		}
+		// next to the originals, so the replacement is a rename on the same file system
+		opts.stagingDir = filepath.Join(backupRoot, ".staging")
 
-		originalImage, err := imageupsizer.GetImageConfigFromFile(path)
+		// the run keeps its own journal of what it backed up, -journal is started over by the next run
+		backups, err := openJournal(filepath.Join(opts.backupDir, backupJournalName), false, false)
 		if err != nil {
-			log.Errorf("GetImageConfigFromFile, %s, %s", path, err.Error())
-			continue
+			log.Fatalf("error opening backup journal: %s", err)
 		}
+		defer closeBackupJournal(backups, opts.backupDir)
+		opts.backups = backups
+	}
+	if err := os.MkdirAll(opts.stagingDir, os.ModePerm); err != nil {
+		log.Fatalf("error creating staging dir: %s", err)
+	}
+	// only removed when it's empty
+	defer os.Remove(opts.stagingDir)
 
-		rename, _, err := imageupsizer.Convert(largerImage.LocalPath)
-		if err != nil {
-			log.Errorf("error converting image: %s, err: %v", path, err)
-			continue
+	var warnings []logrus.Fields
+	var upsize = func(path string) result {
+		var res = upsizeFile(path, outputEntry, opts)
+		if err := journal.record(res.journalEntry()); err != nil {
+			log.Errorf("error writing journal: %s", err)
 		}
+		if res.backup != "" {
+			if err := opts.backups.record(res.journalEntry()); err != nil {
+				log.Errorf("[%s] error writing backup journal, undo it with -journal: %s", path, err)
+			}
+		}
+		return res
+	}
 
-		// rename larger image to same name as original
-		err = os.Rename(rename, filepath.Join(outputEntry, filepath.Base(path)))
-		if err != nil {
-			log.Errorf("replace old file, %s, %s", path, err.Error())
-			continue
+	var started = runPool(files, workers, signals, upsize, func(res result) {
+		counts[res.status]++
+		var row = res.reportRow()
+		rows = append(rows, row)
+
+		switch res.status {
+		case statusNoLarger, statusRejected:
+			log.Tracef("[%s] Larger image not available: %v", res.path, res.err)
+			return
+		case statusFailed:
+			log.Errorf("%s, %v", res.path, res.err)
+			return
+		case statusSkipped:
+			log.Infof("[%s] Skipped: %v", res.path, res.err)
+			return
 		}
 
-		var areaIncrease = ((float64(largerImage.Area) - float64(originalImage.Area)) / float64(originalImage.Area)) * 100
-		var fileIncrease = ((float64(largerImage.FileSize) - float64(originalImage.FileSize)) / float64(originalImage.FileSize)) * 100
+		var areaIncrease = row.AreaIncrease
+		var fileIncrease = row.SizeIncrease
 
 		if fileIncrease > areaIncrease {
 			warnings = append(warnings, log.Fields{
-				"path":               originalImage.LocalPath,
-				"original area":      originalImage.Area,
-				"new area":           largerImage.Area,
+				"path":               res.original.LocalPath,
+				"original area":      res.original.Area,
+				"new area":           res.larger.Area,
 				"area increace":      fmt.Sprintf("%.2f%%", areaIncrease),
 				"file size increace": fmt.Sprintf("%.2f%%", fileIncrease),
 			})
 		}
 		log.WithFields(log.Fields{
-			"path":               originalImage.LocalPath,
-			"original area":      originalImage.Area,
-			"new area":           largerImage.Area,
+			"path":               res.original.LocalPath,
+			"original area":      res.original.Area,
+			"new area":           res.larger.Area,
 			"area increace":      fmt.Sprintf("%.2f%%", areaIncrease),
 			"file size increace": fmt.Sprintf("%.2f%%", fileIncrease),
 		}).Info("upsized image")
-	}
+	})
 
 	for _, f := range warnings {
 		log.WithFields(f).Warn("upsized image is a lot bigger in file size")
 	}
+
+	fmt.Printf("upsized: %d, no larger image: %d, rejected: %d, skipped: %d, failed: %d, not started: %d\n", counts[statusDone], counts[statusNoLarger], counts[statusRejected], counts[statusSkipped], counts[statusFailed], len(files)-started)
+}
+
+// result is the outcome of upsizing one file
+type result struct {
+	path   string
+	status string
+	err    error
+	output string
+	// outputSize is the file size of output, after it was converted
+	outputSize int64
+	backup     string
+	original   *imageupsizer.ImageData
+	larger     *imageupsizer.ImageData
+	elapsed    time.Duration
+	// sidecar is the provenance file written next to output
+	sidecar string
+}
+
+func (r result) journalEntry() journalEntry {
+	var entry = journalEntry{
+		Path:    r.path,
+		Status:  r.status,
+		Output:  r.output,
+		Backup:  r.backup,
+		Sidecar: r.sidecar,
+	}
+	if r.status == statusFailed {
+		entry.ErrorClass = imageupsizer.ErrorClass(r.err)
+		entry.Error = r.err.Error()
+	}
+	return entry
+}
+
+// outputOptions is what upsizeFile does with a larger image after downloading it
+type outputOptions struct {
+	convert  imageupsizer.ConvertOptions
+	metadata imageupsizer.MetadataPolicy
+	// sidecar is the format of the provenance file written next to the output, none when it's empty
+	sidecar         imageupsizer.SidecarFormat
+	embedProvenance bool
+	// nameTemplate names the output under the output dir, see imageupsizer.ExpandName
+	nameTemplate string
+	collision    imageupsizer.CollisionPolicy
+	// inputRoot is the input dir, the tree under it is mirrored by {dir}
+	inputRoot string
+	// stagingDir is where images are downloaded to and converted in
+	stagingDir string
+	// inPlace replaces the originals, they are moved under backupDir and recorded in backups
+	inPlace   bool
+	backupDir string
+	backups   *journal
+}
+
+// upsizeFile finds, downloads and converts the larger version of path, copies the metadata of path onto it
+// and moves it to the name opts.nameTemplate gives it in outputDir
+func upsizeFile(path, outputDir string, opts outputOptions) result {
+	var res = result{path: path, status: statusFailed}
+
+	// the report has the original of every file, whether a larger one is found or not
+	originalImage, err := imageupsizer.GetImageConfigFromFile(path)
+	if err != nil {
+		res.err = fmt.Errorf("GetImageConfigFromFile: %w", err)
+		return res
+	}
+	res.original = originalImage
+
+	largerImage, err := imageupsizer.GetLargerImageFromFile(path, opts.stagingDir)
+	if err != nil {
+		if notAvailable(err) {
+			res.status = notAvailableStatus(err)
+			res.err = err
+			return res
+		}
+		res.err = fmt.Errorf("GetLargerImageFromFile: %w", err)
+		return res
+	}
+	res.larger = largerImage
+
+	// nothing that failed is left in the staging dir
+	var staged = []string{largerImage.LocalPath}
+	defer func() {
+		if res.status == statusDone {
+			return
+		}
+		for _, filename := range staged {
+			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				log.Warnf("[%s] error removing download: %v", path, err)
+			}
+		}
+	}()
+
+	converted, err := imageupsizer.ConvertWithOptions(largerImage.LocalPath, opts.convert)
+	if err != nil {
+		res.err = fmt.Errorf("error converting image: %w", err)
+		return res
+	}
+	var rename = converted.File
+	staged = append(staged, rename)
+	log.Tracef("[%s] converted %s to %s, alpha: %s", path, converted.InputType, converted.Format, converted.Alpha)
+
+	// the larger image is still usable without the metadata, e.g. when it was kept as webp
+	if err := imageupsizer.CopyMetadata(path, rename, opts.metadata); err != nil {
+		log.Warnf("[%s] error copying metadata: %v", path, err)
+	}
+
+	var output string
+	if opts.inPlace {
+		var backup = backupPath(opts, path)
+		// once the original is replaced its backup is recorded, even when a later step fails, so undo can put it back
+		if output, err = imageupsizer.ReplaceWithBackup(path, rename, backup); output != "" {
+			res.backup = backup
+			res.output = output
+		}
+	} else {
+		output, err = moveToOutput(path, rename, outputDir, largerImage, opts)
+	}
+	if err != nil {
+		if errors.Is(err, imageupsizer.ErrOutputExists) {
+			res.status = statusSkipped
+		}
+		res.err = err
+		return res
+	}
+
+	res.output = output
+	res.status = statusDone
+	if info, err := os.Stat(output); err == nil {
+		res.outputSize = info.Size()
+	}
+
+	keepDownload(path, largerImage.LocalPath, rename, output, opts)
+
+	if opts.sidecar != "" || opts.embedProvenance {
+		if res.sidecar, err = recordProvenance(originalImage, largerImage, output, opts); err != nil {
+			log.Warnf("[%s] error recording provenance: %v", path, err)
+		}
+	}
+	return res
+}
+
+// keepDownload keeps the download next to output for -keep-original, not in the staging dir
+func keepDownload(path, download, converted, output string, opts outputOptions) {
+	if !opts.convert.KeepOriginal || converted == download {
+		return
+	}
+	var kept, err = imageupsizer.MoveToOutput(download, strings.TrimSuffix(output, filepath.Ext(output))+filepath.Ext(download), imageupsizer.CollisionSuffix)
+	if err != nil {
+		log.Warnf("[%s] error keeping the download: %v", path, err)
+		_ = os.Remove(download)
+		return
+	}
+	log.Tracef("[%s] kept the download as %s", path, kept)
+}
+
+// moveToOutput moves the converted larger image to the name opts.nameTemplate gives it
+func moveToOutput(path, converted, outputDir string, largerImage *imageupsizer.ImageData, opts outputOptions) (string, error) {
+	var fields, err = imageupsizer.NewNameFields(opts.inputRoot, path, converted, largerImage)
+	if err != nil {
+		return "", err
+	}
+	name, err := imageupsizer.ExpandName(opts.nameTemplate, fields)
+	if err != nil {
+		return "", err
+	}
+	return imageupsizer.MoveToOutput(converted, filepath.Join(outputDir, name), opts.collision)
 }
 
-// getFileList filters the file list
+// backupPath is where -in-place keeps the original at path, at the same place under the backup dir as under the input
+func backupPath(opts outputOptions, path string) string {
+	var rel, err = filepath.Rel(opts.inputRoot, path)
+	if opts.inputRoot == "" || err != nil || strings.HasPrefix(rel, "..") {
+		// outside the input, e.g. retried without -input, the absolute path keeps them apart
+		rel = strings.TrimPrefix(filepath.Clean(path), string(filepath.Separator))
+	}
+	return filepath.Join(opts.backupDir, rel)
+}
+
+// recordProvenance writes where the larger image at output came from to a sidecar and/or its xmp,
+// it returns the name of the sidecar
+func recordProvenance(originalImage, largerImage *imageupsizer.ImageData, output string, opts outputOptions) (string, error) {
+	var provenance, err = imageupsizer.NewProvenance(originalImage, largerImage)
+	if err != nil {
+		return "", err
+	}
+	var sidecar string
+	if opts.sidecar != "" {
+		if sidecar, err = imageupsizer.WriteSidecar(output, provenance, opts.sidecar); err != nil {
+			return "", err
+		}
+	}
+	if opts.embedProvenance {
+		return sidecar, imageupsizer.EmbedProvenance(output, provenance)
+	}
+	return sidecar, nil
+}
+
+// findLargerImage is the search a dry run does, tests swap it for a fake one
+var findLargerImage = imageupsizer.FindLargerImageFromFile
+
+// findFile searches for the larger version of path without writing it anywhere
+func findFile(path string) result {
+	var res = result{path: path, status: statusFailed}
+
+	originalImage, err := imageupsizer.GetImageConfigFromFile(path)
+	if err != nil {
+		res.err = fmt.Errorf("GetImageConfigFromFile: %w", err)
+		return res
+	}
+	res.original = originalImage
+
+	largerImage, err := findLargerImage(path)
+	if err != nil {
+		if notAvailable(err) {
+			res.status = notAvailableStatus(err)
+			res.err = err
+			return res
+		}
+		res.err = fmt.Errorf("FindLargerImageFromFile: %w", err)
+		return res
+	}
+	res.larger = largerImage
+
+	res.status = statusDone
+	return res
+}
+
+// reportFound prints what a dry run found for one file to w
+func reportFound(w io.Writer, res result) {
+	switch res.status {
+	case statusRejected:
+		fmt.Fprintf(w, "%s: %dx%d, larger image rejected: %s\n", res.path, res.original.Width, res.original.Height, res.err)
+	case statusNoLarger:
+		fmt.Fprintf(w, "%s: %dx%d, no larger image\n", res.path, res.original.Width, res.original.Height)
+	case statusFailed:
+		log.Errorf("%s, %v", res.path, res.err)
+	default:
+		var areaIncrease = increase(res.original.Area, res.larger.Area)
+		fmt.Fprintf(w, "%s: %dx%d -> %dx%d (+%.2f%%) from %s\n", res.path, res.original.Width, res.original.Height,
+			res.larger.Width, res.larger.Height, areaIncrease, sourceHost(res.larger.URL))
+	}
+}
+
+// sourceHost is the host the larger image would be downloaded from
+func sourceHost(link string) string {
+	var u, err = url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+	return u.Host
+}
+
+// unfinished drops the files the journal says are done, have no larger image or were skipped.
+// Files whose larger image was rejected are kept, the thresholds may have been lowered since.
+func unfinished(j *journal, files []string) []string {
+	var remaining = make([]string, 0, len(files))
+	for _, path := range files {
+		if entry, ok := j.status(path); ok && (entry.Status == statusDone || entry.Status == statusNoLarger || entry.Status == statusSkipped) {
+			continue
+		}
+		remaining = append(remaining, path)
+	}
+	return remaining
+}
+
+// upsizeURL puts the larger version of a single image url in outputDir, named by opts.nameTemplate
+// after the file name in the url, and records where it came from
+func upsizeURL(link, outputDir string, opts outputOptions) {
+	originalImage, err := imageupsizer.GetImageConfigFromURL(link)
+	if err != nil {
+		log.Errorf("GetImageConfigFromURL, %s, %v", link, err)
+		return
+	}
+
+	// the download waits here until it has its final name, like the -input files
+	var stagingDir = filepath.Join(outputDir, ".imageupsizer-staging")
+	if err := os.MkdirAll(stagingDir, os.ModePerm); err != nil {
+		log.Errorf("error creating staging dir: %s", err)
+		return
+	}
+	defer os.Remove(stagingDir)
+
+	largerImage, err := imageupsizer.GetLargerImageFromURL(link, stagingDir)
+	if err != nil {
+		if notAvailable(err) {
+			log.Infof("[%s] Larger image not available", link)
+			return
+		}
+		log.Errorf("GetLargerImageFromURL, %s, %v", link, err)
+		return
+	}
+	// whatever is still staged when we're done was not used
+	var staged = []string{largerImage.LocalPath}
+	defer func() {
+		for _, filename := range staged {
+			_ = os.Remove(filename)
+		}
+	}()
+
+	converted, err := imageupsizer.ConvertWithOptions(largerImage.LocalPath, opts.convert)
+	if err != nil {
+		log.Errorf("error converting image: %s, err: %v", largerImage.LocalPath, err)
+		return
+	}
+	staged = append(staged, converted.File)
+
+	output, err := moveToOutput(urlFileName(link), converted.File, outputDir, largerImage, opts)
+	if errors.Is(err, imageupsizer.ErrOutputExists) {
+		log.Infof("[%s] Skipped: %v", link, err)
+		return
+	} else if err != nil {
+		log.Errorf("error moving image to output: %s, err: %v", converted.File, err)
+		return
+	}
+	keepDownload(link, largerImage.LocalPath, converted.File, output, opts)
+
+	if opts.sidecar != "" || opts.embedProvenance {
+		// the original is only known by its url
+		var original = &imageupsizer.ImageData{URL: link, Config: image.Config{Width: originalImage.Width, Height: originalImage.Height}}
+		if _, err := recordProvenance(original, largerImage, output, opts); err != nil {
+			log.Warnf("[%s] error recording provenance: %v", link, err)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"url":      link,
+		"path":     output,
+		"format":   converted.Format,
+		"alpha":    converted.Alpha,
+		"new area": largerImage.Area,
+		"source":   largerImage.URL,
+		"provider": largerImage.Provider,
+	}).Info("upsized image")
+}
+
+// urlFileName is the file name at the end of the path of link, {name} and {origext} of -url images
+func urlFileName(link string) string {
+	var u, err = url.Parse(link)
+	if err != nil {
+		return "image"
+	}
+	var name = u.Path[strings.LastIndex(u.Path, "/")+1:]
+	if name == "" || name == "." || name == ".." {
+		return "image"
+	}
+	return name
+}
+
+// findURL prints the larger version of a single image url without writing it anywhere
+func findURL(link string) {
+	largerImage, err := imageupsizer.FindLargerImageFromURL(link)
+	if err != nil {
+		if notAvailable(err) {
+			fmt.Printf("%s: no larger image\n", link)
+			return
+		}
+		log.Errorf("FindLargerImageFromURL, %s, %v", link, err)
+		return
+	}
+	fmt.Printf("%s: %dx%d from %s\n", link, largerImage.Width, largerImage.Height, sourceHost(largerImage.URL))
+}
+
+// parseGain sets the minimum area gain from pixels, 50000, or a percentage, 20%
+func parseGain(gain string, t *imageupsizer.Thresholds) error {
+	if gain == "" {
+		return nil
+	}
+	if percent, ok := strings.CutSuffix(gain, "%"); ok {
+		var value, err = strconv.ParseFloat(percent, 64)
+		if err != nil {
+			return fmt.Errorf("invalid -min-gain: %s, error: %w", gain, err)
+		}
+		t.MinAreaGainPercent = value
+		return nil
+	}
+	var value, err = strconv.Atoi(gain)
+	if err != nil {
+		return fmt.Errorf("invalid -min-gain: %s, error: %w", gain, err)
+	}
+	t.MinAreaGain = value
+	return nil
+}
+
+// parseResolution sets the minimum resolution from WIDTHxHEIGHT
+func parseResolution(resolution string, t *imageupsizer.Thresholds) error {
+	if resolution == "" {
+		return nil
+	}
+	if _, err := fmt.Sscanf(strings.ToLower(resolution), "%dx%d", &t.MinWidth, &t.MinHeight); err != nil {
+		return fmt.Errorf("invalid -min-resolution: %s, must be WIDTHxHEIGHT, error: %w", resolution, err)
+	}
+	return nil
+}
+
+// parseColor parses a #rrggbb color
+func parseColor(hex string) (color.Color, error) {
+	var c = color.RGBA{A: 0xff}
+	if _, err := fmt.Sscanf(strings.TrimPrefix(hex, "#"), "%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return nil, fmt.Errorf("invalid -background: %s, must be #rrggbb, error: %w", hex, err)
+	}
+	return c, nil
+}
+
+// notAvailable reports whether err just means there is no larger image
+func notAvailable(err error) bool {
+	return imageupsizer.ErrorClass(err) == imageupsizer.ClassNoLarger
+}
+
+// notAvailableStatus is the status of a file notAvailable is true for
+func notAvailableStatus(err error) string {
+	var thresholdErr *imageupsizer.ThresholdError
+	if errors.As(err, &thresholdErr) {
+		return statusRejected
+	}
+	return statusNoLarger
+}
+
+// getFileList finds the images among the input files by their contents, hidden and system files are skipped
 func getFileList(inputPath path.Entry, modSince humantime.TimeRange) []string {
 
 	var nilTime = time.Time{}
@@ -149,8 +813,36 @@ func getFileList(inputPath path.Entry, modSince humantime.TimeRange) []string {
 		trimmedFileList = path.FilterEntities(trimmedFileList, path.NewDateEntitiesFilter(modSince.From, modSince.To))
 	}
 
-	trimmedFileList = path.FilterEntities(trimmedFileList, path.NewRegexEntitiesFilter(regexp.MustCompile(".*.jpg$|.*.jpeg$|.*.png$|.*.webp$")))
+	trimmedFileList = path.FilterEntities(trimmedFileList, path.NewFileEntitiesFilter())
+
+	var root = inputPath.AbsolutePath
+	if !inputPath.IsDir() {
+		root = filepath.Dir(root)
+	}
+	var discovered = imageupsizer.DiscoverImages(root, path.OnlyNames(trimmedFileList))
+	reportDiscovered(discovered)
 
 	// these are all the files all the way down the dir tree
-	return path.OnlyNames(trimmedFileList)
+	return discovered.Images
+}
+
+// inputRoot is the dir whose tree is mirrored in the output
+func inputRoot(inputEntry path.Entry) string {
+	if inputEntry.IsDir() {
+		return inputEntry.AbsolutePath
+	}
+	return filepath.Dir(inputEntry.AbsolutePath)
+}
+
+// reportDiscovered logs the files getFileList did not take as they are
+func reportDiscovered(discovered imageupsizer.Discovered) {
+	for _, filename := range discovered.Mislabeled {
+		log.Warnf("%s is a %s image", filename, discovered.Formats[filename])
+	}
+	for filename, err := range discovered.Unclassified {
+		log.Warnf("%s is not an image that can be upsized: %v", filename, err)
+	}
+	if len(discovered.Skipped) > 0 {
+		log.Infof("skipped %d hidden and system files", len(discovered.Skipped))
+	}
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kmulvey/imageupsizer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLFileName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "photo.jpg", urlFileName("https://example.com/images/photo.jpg?w=200"))
+	assert.Equal(t, "image", urlFileName("https://example.com/"))
+	assert.Equal(t, "image", urlFileName("https://example.com"))
+	assert.Equal(t, "image", urlFileName("https://example.com/a/.."))
+}
+
+// not parallel, it swaps the search for a fake one
+func TestDryRun(t *testing.T) {
+	var dir = t.TempDir()
+	var files []string
+	for _, name := range []string{"found.png", "none.png", "rejected.png", "failed.png"} {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 50))))
+		var filename = filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(filename, buf.Bytes(), 0600))
+		files = append(files, filename)
+	}
+
+	var larger = &imageupsizer.ImageData{URL: "https://images.example.com/sunflower.png", Config: image.Config{Width: 200, Height: 100}, Area: 200 * 100}
+	var rejected = imageupsizer.Thresholds{MinAreaGain: 1_000_000}.Check(&imageupsizer.ImageData{Config: image.Config{Width: 100, Height: 50}}, larger)
+	require.Error(t, rejected)
+
+	var search = findLargerImage
+	findLargerImage = func(path string) (*imageupsizer.ImageData, error) {
+		switch filepath.Base(path) {
+		case "found.png":
+			return larger, nil
+		case "none.png":
+			return nil, imageupsizer.ErrNoLargerAvailable
+		case "rejected.png":
+			return nil, rejected
+		}
+		return nil, errors.New("search failed")
+	}
+	t.Cleanup(func() { findLargerImage = search })
+
+	var out bytes.Buffer
+	var statuses []string
+	var started = runPool(files, 2, nil, findFile, func(res result) {
+		statuses = append(statuses, res.status)
+		reportFound(&out, res)
+	})
+	assert.Equal(t, len(files), started)
+	assert.Equal(t, []string{statusDone, statusNoLarger, statusRejected, statusFailed}, statuses)
+	assert.Equal(t, files[0]+": 100x50 -> 200x100 (+300.00%) from images.example.com\n"+
+		files[1]+": 100x50, no larger image\n"+
+		files[2]+": 100x50, larger image rejected: "+rejected.Error()+"\n", out.String())
+
+	// nothing is downloaded or written next to the originals
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{"found.png", "none.png", "rejected.png", "failed.png"}, names)
+}
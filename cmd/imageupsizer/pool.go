@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// indexedResult remembers where in the file list a result belongs so they can be reported in order
+type indexedResult struct {
+	index int
+	result
+}
+
+// runPool upsizes files with a bounded number of workers. report is called from a single
+// goroutine with the results in the same order as files, no matter which worker finishes first.
+// When a signal arrives no more files are started, the ones in flight are finished and reported,
+// and the number of files that were started is returned. After the first signal, whether it
+// arrives while files are being fed or during the final drain, the default handlers are restored
+// so a second one stops the process without waiting for the drain.
+func runPool(files []string, workers int, signals <-chan os.Signal, work func(string) result, report func(result)) int {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var jobs = make(chan int)
+	var results = make(chan indexedResult, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				var start = time.Now()
+				var res = work(files[index])
+				res.elapsed = time.Since(start)
+				results <- indexedResult{index: index, result: res}
+			}
+		}()
+	}
+
+	var started int
+	var drained = make(chan struct{})
+	defer close(drained)
+	go func() {
+		var stop = func(sig os.Signal) {
+			signal.Reset()
+			log.Infof("received %s, finishing the files in progress, send it again to quit now", sig)
+		}
+		for index := range files {
+			select {
+			case sig := <-signals:
+				close(jobs)
+				stop(sig)
+				return
+			case jobs <- index:
+				started++
+			}
+		}
+		close(jobs)
+
+		// every file has been started, keep listening so a signal during the final drain still
+		// restores the default handlers
+		select {
+		case sig := <-signals:
+			stop(sig)
+		case <-drained:
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// hold on to results that finish early until everything before them has been reported
+	var pending = make(map[int]result)
+	var next int
+	for res := range results {
+		pending[res.index] = res.result
+		for {
+			var r, ok = pending[next]
+			if !ok {
+				break
+			}
+			report(r)
+			delete(pending, next)
+			next++
+		}
+	}
+
+	return started
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunPoolOrder(t *testing.T) {
+	t.Parallel()
+
+	var files = make([]string, 20)
+	for i := range files {
+		files[i] = strconv.Itoa(i)
+	}
+
+	// the first files take the longest so the workers finish them last
+	var reported []string
+	var started = runPool(files, 4, nil, func(path string) result {
+		var i, _ = strconv.Atoi(path)
+		time.Sleep(time.Duration(len(files)-i) * time.Millisecond)
+		return result{path: path, status: statusDone}
+	}, func(res result) {
+		assert.NotZero(t, res.elapsed)
+		reported = append(reported, res.path)
+	})
+
+	assert.Equal(t, len(files), started)
+	assert.Equal(t, files, reported)
+}
+
+func TestRunPoolSignal(t *testing.T) {
+	t.Parallel()
+
+	var files = []string{"a", "b", "c", "d", "e"}
+	// unbuffered so the send returns once the feeder has taken the signal
+	var signals = make(chan os.Signal)
+	var inFlight = make(chan string, len(files))
+	var release = make(chan struct{})
+
+	var mu sync.Mutex
+	var reported []string
+	var done = make(chan int)
+	go func() {
+		done <- runPool(files, 2, signals, func(path string) result {
+			inFlight <- path
+			<-release
+			return result{path: path, status: statusDone}
+		}, func(res result) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, res.path)
+		})
+	}()
+
+	// both workers are busy, no more files are started once the signal is in
+	<-inFlight
+	<-inFlight
+	signals <- syscall.SIGINT
+	close(release)
+
+	// the files in flight are finished and reported
+	assert.Equal(t, 2, <-done)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"a", "b"}, reported)
+}
+
+func TestRunPoolSignalDuringDrain(t *testing.T) {
+	t.Parallel()
+
+	var files = []string{"a"}
+	var signals = make(chan os.Signal)
+	var inFlight = make(chan string, len(files))
+	var release = make(chan struct{})
+
+	var reported []string
+	var done = make(chan int)
+	go func() {
+		done <- runPool(files, 2, signals, func(path string) result {
+			inFlight <- path
+			<-release
+			return result{path: path, status: statusDone}
+		}, func(res result) {
+			reported = append(reported, res.path)
+		})
+	}()
+
+	// every file is started, the signal still has to be taken while the last one finishes
+	<-inFlight
+	select {
+	case signals <- syscall.SIGINT:
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal during the final drain was not received")
+	}
+	close(release)
+
+	assert.Equal(t, 1, <-done)
+	assert.Equal(t, []string{"a"}, reported)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kmulvey/imageupsizer"
+)
+
+// reportRow is the outcome of one input file as written to the -report file
+type reportRow struct {
+	Path           string  `json:"path"`
+	Status         string  `json:"status"`
+	ErrorClass     string  `json:"error_class,omitempty"`
+	Error          string  `json:"error,omitempty"`
+	Output         string  `json:"output,omitempty"`
+	OriginalWidth  int     `json:"original_width"`
+	OriginalHeight int     `json:"original_height"`
+	OriginalSize   int64   `json:"original_size"`
+	NewWidth       int     `json:"new_width"`
+	NewHeight      int     `json:"new_height"`
+	NewSize        int64   `json:"new_size"`
+	AreaIncrease   float64 `json:"area_increase"`
+	SizeIncrease   float64 `json:"size_increase"`
+	SourceURL      string  `json:"source_url,omitempty"`
+	Provider       string  `json:"provider,omitempty"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+var reportHeader = []string{
+	"path", "status", "error_class", "error", "output",
+	"original_width", "original_height", "original_size",
+	"new_width", "new_height", "new_size",
+	"area_increase", "size_increase", "source_url", "provider", "elapsed_seconds",
+}
+
+func (r result) reportRow() reportRow {
+	var row = reportRow{
+		Path:           r.path,
+		Status:         r.status,
+		Output:         r.output,
+		ElapsedSeconds: r.elapsed.Seconds(),
+	}
+	if r.err != nil {
+		row.ErrorClass = imageupsizer.ErrorClass(r.err)
+		row.Error = r.err.Error()
+	}
+	if r.original != nil {
+		row.OriginalWidth = r.original.Width
+		row.OriginalHeight = r.original.Height
+		row.OriginalSize = r.original.FileSize
+	}
+	if r.larger != nil {
+		row.NewWidth = r.larger.Width
+		row.NewHeight = r.larger.Height
+		row.NewSize = r.larger.FileSize
+		if r.outputSize > 0 {
+			row.NewSize = r.outputSize
+		}
+		row.SourceURL = r.larger.URL
+		row.Provider = r.larger.Provider
+	}
+	if r.original != nil && r.larger != nil {
+		row.AreaIncrease = increase(r.original.Area, r.larger.Area)
+		// cached and dry run results are never downloaded so their size is not known
+		if row.NewSize > 0 {
+			row.SizeIncrease = increase(r.original.FileSize, row.NewSize)
+		}
+	}
+	return row
+}
+
+// increase is how much bigger to is than from in percent
+func increase[T int | int64](from, to T) float64 {
+	if from == 0 {
+		return 0
+	}
+	return ((float64(to) - float64(from)) / float64(from)) * 100
+}
+
+// writeReport writes the rows as csv when filename ends in .csv and as json otherwise
+func writeReport(filename string, rows []reportRow) error {
+	var file, err = os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating report: %s, error: %w", filename, err)
+	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(filename), ".csv") {
+		err = writeCSVReport(file, rows)
+	} else {
+		var encoder = json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(rows)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing report: %s, error: %w", filename, err)
+	}
+	return file.Close()
+}
+
+func writeCSVReport(file *os.File, rows []reportRow) error {
+	var w = csv.NewWriter(file)
+	if err := w.Write(reportHeader); err != nil {
+		return err
+	}
+
+	var itoa = strconv.Itoa
+	var ftoa = func(f float64) string { return strconv.FormatFloat(f, 'f', 2, 64) }
+	for _, row := range rows {
+		var record = []string{
+			row.Path, row.Status, row.ErrorClass, row.Error, row.Output,
+			itoa(row.OriginalWidth), itoa(row.OriginalHeight), strconv.FormatInt(row.OriginalSize, 10),
+			itoa(row.NewWidth), itoa(row.NewHeight), strconv.FormatInt(row.NewSize, 10),
+			ftoa(row.AreaIncrease), ftoa(row.SizeIncrease), row.SourceURL, row.Provider, ftoa(row.ElapsedSeconds),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
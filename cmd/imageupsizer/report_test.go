@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kmulvey/imageupsizer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportRow(t *testing.T) {
+	t.Parallel()
+
+	var original = &imageupsizer.ImageData{FileSize: 1000, Area: 100 * 50}
+	original.Width, original.Height = 100, 50
+	var larger = &imageupsizer.ImageData{FileSize: 5000, Area: 200 * 100, URL: "https://example.com/a.jpg", Provider: "google"}
+	larger.Width, larger.Height = 200, 100
+
+	// the size is that of the converted output, not of the download
+	var row = result{path: "a.jpg", status: statusDone, output: "out/a.jpg", outputSize: 2000, original: original, larger: larger}.reportRow()
+	assert.Equal(t, 100, row.OriginalWidth)
+	assert.Equal(t, int64(2000), row.NewSize)
+	assert.Equal(t, 300.0, row.AreaIncrease)
+	assert.Equal(t, 100.0, row.SizeIncrease)
+
+	// files without a larger image still have their original
+	row = result{path: "b.jpg", status: statusNoLarger, err: imageupsizer.ErrNoLargerAvailable, original: original}.reportRow()
+	assert.Equal(t, 50, row.OriginalHeight)
+	assert.Equal(t, int64(1000), row.OriginalSize)
+	assert.Equal(t, imageupsizer.ClassNoLarger, row.ErrorClass)
+
+	var dir = t.TempDir()
+	var rows = []reportRow{row, result{path: "c.jpg", status: statusFailed, err: errors.New("broken")}.reportRow()}
+	require.NoError(t, writeReport(filepath.Join(dir, "report.json"), rows))
+	var contents, err = os.ReadFile(filepath.Join(dir, "report.json"))
+	require.NoError(t, err)
+	var decoded []map[string]any
+	require.NoError(t, json.Unmarshal(contents, &decoded))
+	require.Len(t, decoded, 2)
+	// zeros are written too, not left out
+	assert.Equal(t, 0.0, decoded[0]["new_width"])
+	assert.Equal(t, 0.0, decoded[1]["original_width"])
+
+	require.NoError(t, writeReport(filepath.Join(dir, "report.csv"), rows))
+	file, err := os.Open(filepath.Join(dir, "report.csv"))
+	require.NoError(t, err)
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, reportHeader, records[0])
+	assert.Equal(t, "1000", records[1][7])
+}
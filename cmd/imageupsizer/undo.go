@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kmulvey/imageupsizer"
+	log "github.com/sirupsen/logrus"
+)
+
+// backupJournalName is the journal an -in-place run keeps in its backup dir
+const backupJournalName = "journal.jsonl"
+
+// undo puts back the originals -in-place runs replaced, from the backups recorded in their backup dirs
+func undo(args []string) {
+	var flags = flag.NewFlagSet("undo", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: imageupsizer undo BACKUP_RUN_DIR...")
+		fmt.Fprintln(flags.Output(), "puts back the originals an -in-place run moved to its dir under -backup-dir, e.g. .imageupsizer-backup/20060102-150405")
+		flags.PrintDefaults()
+	}
+	// ExitOnError
+	_ = flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var restored, failed int
+	for _, runDir := range flags.Args() {
+		var r, f, err = undoRun(runDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		restored += r
+		failed += f
+	}
+
+	fmt.Printf("restored: %d, failed: %d\n", restored, failed)
+}
+
+// undoRun restores every backup in the journal of runDir that was not restored yet
+func undoRun(runDir string) (int, int, error) {
+	var filename = filepath.Join(runDir, backupJournalName)
+	if _, err := os.Stat(filename); err != nil {
+		return 0, 0, fmt.Errorf("error opening backup journal: %s, error: %w", filename, err)
+	}
+	var backups, err = openJournal(filename, true, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer backups.Close()
+
+	var restored, failed int
+	for _, path := range restorable(backups) {
+		var entry, _ = backups.status(path)
+		if err := imageupsizer.RestoreBackup(path, entry.Output, entry.Backup); err != nil {
+			log.Errorf("%s, %v", path, err)
+			failed++
+			continue
+		}
+		// the sidecar describes the larger image, not the original that is back
+		if entry.Sidecar != "" {
+			if err := os.Remove(entry.Sidecar); err != nil && !os.IsNotExist(err) {
+				log.Warnf("[%s] error removing sidecar: %v", path, err)
+			}
+		}
+		if err := backups.record(journalEntry{Path: path, Status: statusUndone, Output: path, Backup: entry.Backup}); err != nil {
+			log.Errorf("error writing backup journal: %s", err)
+		}
+		log.Infof("[%s] restored from %s", path, entry.Backup)
+		restored++
+	}
+	return restored, failed, nil
+}
+
+// restorable are the paths whose original was backed up and not put back yet, including the
+// files that failed after their original was replaced
+func restorable(backups *journal) []string {
+	var paths []string
+	for _, path := range append(backups.withStatus(statusDone), backups.withStatus(statusFailed)...) {
+		if entry, _ := backups.status(path); entry.Backup != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// newRunDir creates the backup dir of a run under root, named after its start time
+func newRunDir(root string, start time.Time) (string, error) {
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return "", fmt.Errorf("error creating dir: %s, error: %w", root, err)
+	}
+
+	var name = start.Format("20060102-150405")
+	for i := 0; ; i++ {
+		var dir = filepath.Join(root, name)
+		if i > 0 {
+			// two runs started in the same second
+			dir = filepath.Join(root, fmt.Sprintf("%s-%d", name, i))
+		}
+		var err = os.Mkdir(dir, os.ModePerm)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return "", fmt.Errorf("error creating dir: %s, error: %w", dir, err)
+		}
+		return dir, nil
+	}
+}
+
+// closeBackupJournal closes the journal of the run in runDir, the dir is removed when nothing was backed up
+func closeBackupJournal(backups *journal, runDir string) {
+	if err := backups.Close(); err != nil {
+		log.Errorf("error closing backup journal: %s", err)
+	}
+	if len(restorable(backups)) > 0 {
+		fmt.Printf("originals are kept in %s, put them back with: imageupsizer undo %s\n", runDir, runDir)
+		return
+	}
+	_ = os.Remove(filepath.Join(runDir, backupJournalName))
+	_ = os.Remove(runDir)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kmulvey/imageupsizer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndoRun(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var write = func(name, contents string) string {
+		var filename = filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0700))
+		require.NoError(t, os.WriteFile(filename, []byte(contents), 0600))
+		return filename
+	}
+
+	var start = time.Date(2022, 5, 7, 12, 0, 0, 0, time.UTC)
+	var runDir, err = newRunDir(filepath.Join(dir, "backup"), start)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "backup", "20220507-120000"), runDir)
+
+	// a second run in the same second gets its own dir
+	secondRun, err := newRunDir(filepath.Join(dir, "backup"), start)
+	require.NoError(t, err)
+	assert.Equal(t, runDir+"-1", secondRun)
+
+	backups, err := openJournal(filepath.Join(runDir, backupJournalName), false, false)
+	require.NoError(t, err)
+
+	var photo = write("input/photo.jpg", "photo")
+	var icon = write("input/icon.png", "icon")
+	for _, replace := range []struct{ original, upsized string }{
+		{photo, write("staging/photo.jpg", "larger photo")},
+		{icon, write("staging/icon.jpg", "larger icon")},
+	} {
+		var backup = filepath.Join(runDir, filepath.Base(replace.original))
+		var output, err = imageupsizer.ReplaceWithBackup(replace.original, replace.upsized, backup)
+		require.NoError(t, err)
+		var sidecar = write("input/"+filepath.Base(output)+".json", "{}")
+		require.NoError(t, backups.record(journalEntry{Path: replace.original, Status: statusDone, Output: output, Backup: backup, Sidecar: sidecar}))
+	}
+	// a file that failed has no backup and is left alone
+	require.NoError(t, backups.record(journalEntry{Path: write("input/other.jpg", "other"), Status: statusFailed}))
+	// one that failed after its original was replaced is put back
+	var late = write("input/late.jpg", "late")
+	lateOutput, err := imageupsizer.ReplaceWithBackup(late, write("staging/late.jpg", "larger late"), filepath.Join(runDir, "late.jpg"))
+	require.NoError(t, err)
+	require.NoError(t, backups.record(journalEntry{Path: late, Status: statusFailed, Error: "error syncing dir", Output: lateOutput, Backup: filepath.Join(runDir, "late.jpg")}))
+	require.NoError(t, backups.Close())
+
+	assert.Equal(t, "larger photo", readFile(t, photo))
+	assert.NoFileExists(t, icon)
+
+	restored, failed, err := undoRun(runDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, restored)
+	assert.Equal(t, 0, failed)
+	assert.Equal(t, "late", readFile(t, late))
+	assert.Equal(t, "photo", readFile(t, photo))
+	assert.Equal(t, "icon", readFile(t, icon))
+	assert.NoFileExists(t, filepath.Join(dir, "input/icon.jpg"))
+	// the sidecars of the larger images go with them
+	assert.NoFileExists(t, photo+".json")
+	assert.NoFileExists(t, filepath.Join(dir, "input/icon.jpg.json"))
+	assert.Equal(t, "other", readFile(t, filepath.Join(dir, "input/other.jpg")))
+
+	// the journal records the undo so a second undo does nothing
+	restored, failed, err = undoRun(runDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, restored)
+	assert.Equal(t, 0, failed)
+
+	_, _, err = undoRun(secondRun)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCloseBackupJournal(t *testing.T) {
+	t.Parallel()
+
+	var root = t.TempDir()
+	var runDir, err = newRunDir(root, time.Now())
+	require.NoError(t, err)
+	backups, err := openJournal(filepath.Join(runDir, backupJournalName), false, false)
+	require.NoError(t, err)
+
+	// nothing was backed up, the run leaves no dir behind
+	closeBackupJournal(backups, runDir)
+	assert.NoDirExists(t, runDir)
+}
+
+func readFile(t *testing.T, filename string) string {
+	t.Helper()
+
+	var contents, err = os.ReadFile(filename)
+	assert.NoError(t, err)
+	return string(contents)
+}
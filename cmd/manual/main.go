@@ -1,10 +1,11 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/kmulvey/imageupsizer"
@@ -16,9 +17,11 @@ func main() {
 	var newFilesEntry path.Entry
 	var oldFilesEntry path.Entry
 	var logLevel string
+	var collision string
 	flag.Var(&newFilesEntry, "new-files", "path to files, globbing must be quoted")
 	flag.Var(&oldFilesEntry, "old-files", "A directory to put the larger image in")
 	flag.StringVar(&logLevel, "log-level", "info", "Set the level of log output: (info, warn, error)")
+	flag.StringVar(&collision, "collision", string(imageupsizer.CollisionSkip), "what to do when a converted image would replace another old file: skip, overwrite or suffix")
 	flag.Parse()
 
 	switch strings.ToLower(logLevel) {
@@ -34,6 +37,13 @@ func main() {
 		flag.PrintDefaults()
 	}
 
+	var policy = imageupsizer.CollisionPolicy(collision)
+	switch policy {
+	case imageupsizer.CollisionSkip, imageupsizer.CollisionOverwrite, imageupsizer.CollisionSuffix:
+	default:
+		log.Fatalf("invalid -collision: %s, must be skip, overwrite or suffix", collision)
+	}
+
 	var newFiles, err = newFilesEntry.Flatten(false)
 	if err != nil {
 		log.Fatalf("error flattening newFiles: %s", err)
@@ -50,32 +60,104 @@ func main() {
 		return
 	}
 
-	var files = path.FilterEntities(newFiles, path.NewRegexEntitiesFilter(regexp.MustCompile(".*.jpg$|.*.jpeg$|.*.png$|.*.webp$")))
+	var discovered = imageupsizer.DiscoverImages(newFilesEntry.String(), path.OnlyNames(path.FilterEntities(newFiles, path.NewFileEntitiesFilter())))
+	for filename, err := range discovered.Unclassified {
+		log.Warnf("%s is not an image: %v", filename, err)
+	}
 
-	for _, file := range files {
-		var newImage, err = imageupsizer.GetImageConfigFromFile(file.AbsolutePath)
+	for _, file := range discovered.Images {
+		var newImage, err = imageupsizer.GetImageConfigFromFile(file)
+		if err != nil {
+			log.Errorf("GetImageConfigFromFile, %s, %s", file, err.Error())
+			continue
+		}
+		oldFile, err := findOriginal(newFilesEntry.String(), oldFilesEntry.String(), file)
 		if err != nil {
-			log.Errorf("GetImageConfigFromFile, %s, %s", file.AbsolutePath, err.Error())
+			log.Errorf("findOriginal, %s, %s", file, err.Error())
 			continue
 		}
-		oldImage, err := imageupsizer.GetImageConfigFromFile(filepath.Join(oldFilesEntry.String(), filepath.Base(file.AbsolutePath)))
+		oldImage, err := imageupsizer.GetImageConfigFromFile(oldFile)
 		if err != nil {
-			log.Errorf("GetImageConfigFromFile, %s, %s", file.AbsolutePath, err.Error())
+			log.Errorf("GetImageConfigFromFile, %s, %s", oldFile, err.Error())
 			continue
 		}
 
 		if newImage.Area > oldImage.Area {
-			err = os.Rename(newImage.LocalPath, filepath.Join(oldFilesEntry.String(), filepath.Base(file.AbsolutePath)))
-			if err != nil {
-				log.Errorf("rename %s to %s, err: %s", newImage.LocalPath, filepath.Join(oldFilesEntry.String(), filepath.Base(file.AbsolutePath)), err.Error())
+			// the new file keeps its extension, it may have been converted to another format
+			var to = strings.TrimSuffix(oldFile, filepath.Ext(oldFile)) + filepath.Ext(file)
+			// the old file is meant to be replaced, any other file with the new name is not
+			var toPolicy = policy
+			if to == oldFile {
+				toPolicy = imageupsizer.CollisionOverwrite
+			}
+			moved, err := imageupsizer.MoveToOutput(newImage.LocalPath, to, toPolicy)
+			if errors.Is(err, imageupsizer.ErrOutputExists) {
+				log.Warnf("skipping %s, %s", newImage.LocalPath, err.Error())
+				continue
+			} else if err != nil {
+				log.Errorf("rename %s to %s, err: %s", newImage.LocalPath, to, err.Error())
 				continue
 			}
+			to = moved
+			if to != oldFile {
+				if err := os.Remove(oldFile); err != nil {
+					log.Errorf("remove %s, err: %s", oldFile, err.Error())
+				}
+			}
 			log.WithFields(log.Fields{
 				"old":  oldImage.Area,
 				"new":  newImage.Area,
 				"from": newImage.LocalPath,
-				"to":   filepath.Join(oldFilesEntry.String(), filepath.Base(newImage.LocalPath)),
+				"to":   to,
 			}).Info("move")
 		}
 	}
 }
+
+// findOriginal finds the original of an upsized file. The provenance sidecar records it, without one it's
+// looked for at the same place in the old tree as in the new one, with the same name or the same name
+// and the extension of another image format. That only works for the default -name template.
+func findOriginal(newRoot, oldRoot, file string) (string, error) {
+	if provenance, err := imageupsizer.ReadSidecar(file); err == nil && provenance.OriginalPath != "" {
+		if within(oldRoot, provenance.OriginalPath) {
+			return provenance.OriginalPath, nil
+		}
+		log.Warnf("%s is the upsized %s, which is not in: %s", file, provenance.OriginalPath, oldRoot)
+	}
+
+	var rel, err = filepath.Rel(newRoot, file)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(file)
+	}
+	var oldFile = filepath.Join(oldRoot, rel)
+	if _, err := os.Stat(oldFile); err == nil {
+		return oldFile, nil
+	}
+
+	var stem = strings.TrimSuffix(oldFile, filepath.Ext(oldFile))
+	for _, ext := range imageupsizer.Extensions() {
+		for _, candidate := range []string{stem + ext, stem + strings.ToUpper(ext)} {
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no original for: %s in: %s", rel, oldRoot)
+}
+
+// within reports whether filename exists and is in dir
+func within(dir, filename string) bool {
+	if _, err := os.Stat(filename); err != nil {
+		return false
+	}
+	var absDir, err = filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absFile, err := filepath.Abs(filename)
+	if err != nil {
+		return false
+	}
+	var rel, relErr = filepath.Rel(absDir, absFile)
+	return relErr == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
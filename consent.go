@@ -0,0 +1,141 @@
+package imageupsizer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const consentHost = "consent.google.com"
+
+// acceptConsentJS submits the "Accept all" form on consent.google.com.
+// The accept and reject forms only differ by the hidden set_eom field.
+const acceptConsentJS = `(function() {
+	var forms = document.querySelectorAll('form[action$="/save"]');
+	for (var i = 0; i < forms.length; i++) {
+		var eom = forms[i].querySelector('input[name="set_eom"]');
+		if (eom && eom.value === "false") {
+			forms[i].submit();
+			return true;
+		}
+	}
+	if (forms.length > 0) {
+		forms[forms.length - 1].submit();
+		return true;
+	}
+	return false;
+})()`
+
+// isConsentPage reports whether u is google's consent wall.
+func isConsentPage(u *url.URL) bool {
+	return u != nil && u.Hostname() == consentHost
+}
+
+// acceptConsent posts the "Accept all" form found in the consent page html
+// so the resulting cookies end up in the jar.
+func acceptConsent(client *http.Client, pageURL *url.URL, page []byte) error {
+	var values, action, err = findConsentForm(string(page))
+	if err != nil {
+		return err
+	}
+
+	actionURL, err := pageURL.Parse(action)
+	if err != nil {
+		return fmt.Errorf("error parsing consent form action: %s, error: %w", action, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, actionURL.String(), strings.NewReader(values.Encode()))
+	if err != nil {
+		return fmt.Errorf("error creating consent request, error: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("user-agent", userAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending consent request, error: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: non 2xx resp code: %d", ErrConsent, resp.StatusCode)
+	}
+	return nil
+}
+
+// findConsentForm returns the hidden fields and action of the "Accept all" form.
+func findConsentForm(page string) (url.Values, string, error) {
+	var root, err = parseHTML("consent", page)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrConsent, err.Error())
+	}
+
+	var forms = findAll(root, func(n *html.Node) bool {
+		return n.DataAtom == atom.Form && strings.Contains(attr(n, "action"), "/save")
+	})
+	if len(forms) == 0 {
+		return nil, "", fmt.Errorf("%w: no consent form found", ErrConsent)
+	}
+
+	// the last form is accept all in every layout we have seen, prefer the explicit marker if it's there
+	var chosen = forms[len(forms)-1]
+	var values = hiddenInputs(chosen)
+	for _, form := range forms {
+		if fields := hiddenInputs(form); fields.Get("set_eom") == "false" {
+			chosen = form
+			values = fields
+			break
+		}
+	}
+
+	return values, attr(chosen, "action"), nil
+}
+
+// hiddenInputs are the name and value of every hidden input in the form, inputs without a name are not sent.
+func hiddenInputs(form *html.Node) url.Values {
+	var values = url.Values{}
+	var inputs = findAll(form, func(n *html.Node) bool {
+		return n.DataAtom == atom.Input && strings.EqualFold(attr(n, "type"), "hidden") && hasAttr(n, "name")
+	})
+	for _, input := range inputs {
+		values.Add(attr(input, "name"), attr(input, "value"))
+	}
+	return values
+}
+
+// acceptConsentInChrome clicks through the consent wall if chrome landed on it
+// and then navigates back to the page that was originally requested.
+func acceptConsentInChrome(ctx context.Context, target string) error {
+	var location string
+	if err := chromedp.Run(ctx, chromedp.Location(&location)); err != nil {
+		return err
+	}
+	u, err := url.Parse(location)
+	if err != nil || !isConsentPage(u) {
+		return err
+	}
+
+	var submitted bool
+	if err := chromedp.Run(ctx,
+		chromedp.WaitReady(`form`),
+		chromedp.Evaluate(acceptConsentJS, &submitted),
+	); err != nil {
+		return fmt.Errorf("%w: %s", ErrConsent, err.Error())
+	}
+	if !submitted {
+		return ErrConsent
+	}
+
+	return chromedp.Run(ctx,
+		chromedp.Poll(`location.hostname !== "`+consentHost+`"`, nil),
+		chromedp.Navigate(target),
+	)
+}
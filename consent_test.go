@@ -0,0 +1,70 @@
+package imageupsizer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindConsentForm(t *testing.T) {
+	t.Parallel()
+
+	// the accept all form is the one with set_eom false, whatever its place
+	var values, action, err = findConsentForm(readFixture(t, "consent.html"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://consent.google.com/save", action)
+	assert.Equal(t, "false", values.Get("set_eom"))
+	assert.Equal(t, "https://lens.google.com/upload?re=df&ep=gisbubb", values.Get("continue"))
+	assert.Equal(t, "DE", values.Get("gl"))
+	assert.Len(t, values, 11)
+
+	// without the marker the last form is accept all
+	_, action, err = findConsentForm(`<form action="/save?reject"><input type="hidden" name="a" value="1"></form><form action="/save?accept"></form>`)
+	require.NoError(t, err)
+	assert.Equal(t, "/save?accept", action)
+
+	// attributes in any order and quoting
+	values, action, err = findConsentForm(`<FORM method=POST action='/save?x=1&amp;y=2'><input value=false name=set_eom TYPE=Hidden></FORM>`)
+	require.NoError(t, err)
+	assert.Equal(t, "/save?x=1&y=2", action)
+	assert.Equal(t, url.Values{"set_eom": {"false"}}, values)
+
+	_, _, err = findConsentForm(`<form action="/dl"></form>`)
+	assert.ErrorIs(t, err, ErrConsent)
+}
+
+func TestHiddenInputs(t *testing.T) {
+	t.Parallel()
+
+	var root, err = parseHTML("consent", `<form><input type="hidden" name="q" value="a&amp;b"><input type="text" name="visible" value="x">`+
+		`<input type="hidden" value="no name"><input type="hidden" name="empty"><input type="hidden" name="q" value="c"></form>`)
+	require.NoError(t, err)
+	var values = hiddenInputs(root)
+	assert.Equal(t, url.Values{"q": {"a&b", "c"}, "empty": {""}}, values)
+}
+
+func TestAcceptConsent(t *testing.T) {
+	t.Parallel()
+
+	var posted url.Values
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/save", r.URL.Path)
+		assert.NoError(t, r.ParseForm())
+		posted = r.PostForm
+		http.SetCookie(w, &http.Cookie{Name: "SOCS", Value: "accepted"})
+	}))
+	defer server.Close()
+
+	var page, err = url.Parse(server.URL + "/consent")
+	require.NoError(t, err)
+	var j = newCookieJar()
+	var client = &http.Client{Jar: j}
+	require.NoError(t, acceptConsent(client, page, []byte(`<form action="/save" method="POST"><input type="hidden" name="set_eom" value="false"></form>`)))
+	assert.Equal(t, "false", posted.Get("set_eom"))
+	assert.Equal(t, []*http.Cookie{{Name: "SOCS", Value: "accepted"}}, j.Cookies(page))
+}
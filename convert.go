@@ -1,61 +1,271 @@
 package imageupsizer
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
 	"image/jpeg"
+	"image/png"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-// Convert converts pngs and webps to jpeg
+// Format is the file format Convert writes.
+type Format string
+
+const (
+	// FormatKeep leaves images in the format they were downloaded in
+	FormatKeep Format = "keep"
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+)
+
+// Subsampling is what Convert does about chroma subsampling. The jpeg encoder always
+// stores color at half the resolution (4:2:0), which smears fine colored detail like text.
+type Subsampling string
+
+const (
+	// SubsamplingAllow encodes jpegs with 4:2:0 subsampling
+	SubsamplingAllow Subsampling = "allow"
+	// SubsamplingAvoid never throws away color resolution, images that would be
+	// subsampled by the conversion to jpeg are written as png instead
+	SubsamplingAvoid Subsampling = "avoid"
+)
+
+// AlphaPolicy is what Convert does with transparent images when converting to jpeg, which has no transparency.
+type AlphaPolicy string
+
+const (
+	// AlphaKeep writes images that use transparency as png
+	AlphaKeep AlphaPolicy = "keep"
+	// AlphaFlatten draws images that use transparency onto ConvertOptions.Background
+	AlphaFlatten AlphaPolicy = "flatten"
+)
+
+// Alpha decisions reported in ConvertResult
+const (
+	// AlphaOpaque means the image does not use transparency, or it was not converted to jpeg
+	AlphaOpaque = "opaque"
+	// AlphaKept means the image was kept in or converted to png to keep its transparency
+	AlphaKept = "kept"
+	// AlphaFlattened means the transparent parts were filled with the background color
+	AlphaFlattened = "flattened"
+)
+
+// ConvertOptions controls what Convert writes.
+type ConvertOptions struct {
+	Format Format
+	// Quality is the jpeg quality, 1 to 100
+	Quality     int
+	Subsampling Subsampling
+	// KeepLossless never converts png and lossless webp images to jpeg
+	KeepLossless bool
+	Alpha        AlphaPolicy
+	// Background is what transparent images are flattened onto, white when it's nil
+	Background color.Color
+	// KeepOriginal leaves the input file in place next to the converted one
+	KeepOriginal bool
+}
+
+// DefaultConvertOptions is what Convert uses, every png and webp becomes a jpeg
+// unless it is transparent
+var DefaultConvertOptions = ConvertOptions{
+	Format:      FormatJPEG,
+	Quality:     85,
+	Subsampling: SubsamplingAllow,
+	Alpha:       AlphaKeep,
+	Background:  color.White,
+}
+
+// ConvertResult is what ConvertWithOptions did.
+type ConvertResult struct {
+	// File is the name of the new file, it's the input file when it was not converted
+	File string
+	// InputType is the type of the input image (png, webp), as detected from its encoding, not file name
+	InputType string
+	// Format is the format File is in
+	Format Format
+	// Alpha is AlphaOpaque, AlphaKept or AlphaFlattened
+	Alpha string
+}
+
+// Convert converts pngs and webps to jpeg, transparent images are kept as png.
 // this first string returned is the name of the new file
 // the second string returned is the type of the input image (png, webp), as detected from its encoding, not file name
 func Convert(from string) (string, string, error) {
-	var origFile, err = os.Open(from)
+	var result, err = ConvertWithOptions(from, DefaultConvertOptions)
+	return result.File, result.InputType, err
+}
+
+// ConvertWithOptions is just like Convert except the output format and its settings are chosen by opts.
+// Images that are re-encoded are turned the way their exif orientation says, images that are kept keep their exif.
+// Avif and heic images are always kept, see ErrNoDecoder.
+func ConvertWithOptions(from string, opts ConvertOptions) (ConvertResult, error) {
+	var result = ConvertResult{Alpha: AlphaOpaque}
+
+	var contents, err = os.ReadFile(from)
 	if err != nil {
-		return "", "", fmt.Errorf("cannot open image: name: %s, error: %w", from, err)
+		return result, fmt.Errorf("cannot open image: name: %s, error: %w", from, err)
 	}
-	defer origFile.Close()
 
-	var ext = filepath.Ext(from)
-	var newFile string
-	if ext == "" {
-		newFile = from + ".jpg"
-	} else {
-		newFile = strings.Replace(from, ext, ".jpg", 1)
+	imgData, imageType, err := image.Decode(bytes.NewReader(contents))
+	if errors.Is(err, ErrNoDecoder) {
+		// avif and heic can't be re-encoded, but they're still larger
+		result.File = from
+		result.InputType = imageType
+		result.Format = Format(imageType)
+		return result, nil
 	}
-
-	imgData, imageType, err := image.Decode(origFile)
 	if err != nil {
-		return "", "", fmt.Errorf("img decode: name: %s, error: %w", from, err)
+		return result, fmt.Errorf("img decode: name: %s, error: %w", from, err)
 	}
+	result.InputType = imageType
+	var orientation = orientationOf(contents)
 
-	// dont bother converting jpegs
-	if imageType == "jpeg" {
-		return from, "jpeg", nil
+	var format = targetFormat(imageType, isLossless(imageType, contents), opts)
+	if format == FormatJPEG && imageType != "jpeg" && usesAlpha(imgData) {
+		if opts.Alpha == AlphaFlatten {
+			imgData = flatten(imgData, opts.Background)
+			result.Alpha = AlphaFlattened
+		} else {
+			format = FormatPNG
+			result.Alpha = AlphaKept
+		}
 	}
 
-	err = os.Remove(from)
-	if err != nil {
-		return "", "", fmt.Errorf("remove input file: name: %s, error: %w", from, err)
+	// dont bother converting images that are already in the right format
+	if format == FormatKeep || string(format) == imageType {
+		result.File = from
+		result.Format = Format(imageType)
+		return result, nil
 	}
+	result.Format = format
 
-	out, err := os.Create(newFile)
-	if err != nil {
-		return "", "", fmt.Errorf("new jpg create: name: %s, error: %w", from, err)
+	// the encoders don't write exif, the pixels have to be turned the way the orientation tag said
+	imgData = applyOrientation(imgData, orientation)
+
+	var newFile = strings.TrimSuffix(from, filepath.Ext(from)) + "." + extension(format)
+
+	var encode = func(out *os.File) error {
+		var err error
+		switch format {
+		case FormatPNG:
+			err = png.Encode(out, imgData)
+		default:
+			var quality = opts.Quality
+			if quality == 0 {
+				quality = DefaultConvertOptions.Quality
+			}
+			err = jpeg.Encode(out, imgData, &jpeg.Options{Quality: quality})
+		}
+		if err != nil {
+			return fmt.Errorf("%s encode: name: %s, error: %w", format, from, err)
+		}
+		return nil
 	}
 
-	err = jpeg.Encode(out, imgData, &jpeg.Options{Quality: 85})
-	if err != nil {
-		return "", "", fmt.Errorf("jpg encode: name: %s, error: %w", from, err)
+	// the input is only removed once the new file is complete and can be read back
+	if err := writeAtomic(newFile, encode, verifyImage); err != nil {
+		return result, err
 	}
+	result.File = newFile
 
-	err = out.Close()
+	if !opts.KeepOriginal && newFile != from {
+		if err := os.Remove(from); err != nil {
+			return result, fmt.Errorf("remove input file: name: %s, error: %w", from, err)
+		}
+	}
+	return result, nil
+}
+
+// verifyImage decodes the whole file to make sure it was written correctly
+func verifyImage(filename string) error {
+	var file, err = os.Open(filename)
 	if err != nil {
-		return "", "", fmt.Errorf("new jpg close: name: %s, error: %w", from, err)
+		return fmt.Errorf("cannot open image: name: %s, error: %w", filename, err)
 	}
+	defer file.Close()
 
-	return newFile, imageType, nil
+	if _, _, err := image.Decode(file); err != nil {
+		return fmt.Errorf("verify: name: %s, error: %w", filename, err)
+	}
+	return nil
+}
+
+// usesAlpha reports whether any pixel of img is not fully opaque, images with an alpha channel often don't use it
+func usesAlpha(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return !opaque.Opaque()
+	}
+
+	var bounds = img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flatten draws img onto background
+func flatten(img image.Image, background color.Color) image.Image {
+	if background == nil {
+		background = color.White
+	}
+	var bounds = img.Bounds()
+	var flat = image.NewRGBA(bounds)
+	draw.Draw(flat, bounds, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(flat, bounds, img, bounds.Min, draw.Over)
+	return flat
+}
+
+// targetFormat is the format an image of imageType is written in
+func targetFormat(imageType string, lossless bool, opts ConvertOptions) Format {
+	var format = opts.Format
+	if format == "" {
+		format = DefaultConvertOptions.Format
+	}
+	if format != FormatJPEG || imageType == "jpeg" {
+		return format
+	}
+
+	if lossless && opts.KeepLossless {
+		return FormatKeep
+	}
+	// the encoder can only subsample, png is the only way to keep the full color resolution
+	if opts.Subsampling == SubsamplingAvoid {
+		if imageType == "png" {
+			return FormatKeep
+		}
+		return FormatPNG
+	}
+	return format
+}
+
+// isLossless reports whether the encoding of an image of imageType keeps every pixel exactly
+func isLossless(imageType string, contents []byte) bool {
+	switch imageType {
+	case "png", "gif", "bmp", "tiff":
+		return true
+	case "webp":
+		// RIFF, size, WEBP, then the first chunk: VP8 is lossy, VP8L lossless and VP8X
+		// is the extended format which says if there is alpha but not how the image is compressed
+		return bytes.Contains(contents[:min(len(contents), 4096)], []byte("VP8L"))
+	default:
+		return false
+	}
+}
+
+// extension is the file extension for format
+func extension(format Format) string {
+	if format == FormatJPEG {
+		return "jpg"
+	}
+	return string(format)
 }
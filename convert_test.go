@@ -0,0 +1,127 @@
+package imageupsizer
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTargetFormat(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		imageType string
+		lossless  bool
+		opts      ConvertOptions
+		format    Format
+	}{
+		{"png", true, DefaultConvertOptions, FormatJPEG},
+		{"webp", false, DefaultConvertOptions, FormatJPEG},
+		{"jpeg", false, DefaultConvertOptions, FormatJPEG},
+		{"png", true, ConvertOptions{}, FormatJPEG},
+		{"png", true, ConvertOptions{Format: FormatKeep}, FormatKeep},
+		{"jpeg", false, ConvertOptions{Format: FormatPNG}, FormatPNG},
+		{"png", true, ConvertOptions{Format: FormatJPEG, KeepLossless: true}, FormatKeep},
+		{"webp", true, ConvertOptions{Format: FormatJPEG, KeepLossless: true}, FormatKeep},
+		{"webp", false, ConvertOptions{Format: FormatJPEG, KeepLossless: true}, FormatJPEG},
+		{"png", true, ConvertOptions{Format: FormatJPEG, Subsampling: SubsamplingAvoid}, FormatKeep},
+		{"webp", false, ConvertOptions{Format: FormatJPEG, Subsampling: SubsamplingAvoid}, FormatPNG},
+		{"jpeg", false, ConvertOptions{Format: FormatJPEG, Subsampling: SubsamplingAvoid}, FormatJPEG},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.format, targetFormat(test.imageType, test.lossless, test.opts), "%s %+v", test.imageType, test.opts)
+	}
+}
+
+// writePNG writes img to a temp dir and returns its path
+func writePNG(t *testing.T, img image.Image) string {
+	t.Helper()
+
+	var filename = filepath.Join(t.TempDir(), "image.png")
+	var file, err = os.Create(filename)
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(file, img))
+	assert.NoError(t, file.Close())
+	return filename
+}
+
+// testImage is opaque black with a transparent square in the middle when transparent is set
+func testImage(transparent bool) image.Image {
+	var img = image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{A: 0xff})
+			if transparent && x >= 8 && x < 24 && y >= 8 && y < 24 {
+				img.SetNRGBA(x, y, color.NRGBA{})
+			}
+		}
+	}
+	return img
+}
+
+func TestConvertWithOptions(t *testing.T) {
+	t.Parallel()
+
+	var filename = writePNG(t, testImage(false))
+	result, err := ConvertWithOptions(filename, ConvertOptions{Format: FormatJPEG, Quality: 50})
+	assert.NoError(t, err)
+	assert.Equal(t, ConvertResult{File: filepath.Join(filepath.Dir(filename), "image.jpg"), InputType: "png", Format: FormatJPEG, Alpha: AlphaOpaque}, result)
+	assert.Equal(t, 50, jpegQuality(readFile(t, result.File)))
+	assert.NoFileExists(t, filename)
+
+	filename = writePNG(t, testImage(false))
+	result, err = ConvertWithOptions(filename, ConvertOptions{Format: FormatJPEG, KeepLossless: true})
+	assert.NoError(t, err)
+	assert.Equal(t, ConvertResult{File: filename, InputType: "png", Format: "png", Alpha: AlphaOpaque}, result)
+	assert.FileExists(t, filename)
+}
+
+func TestConvertAlpha(t *testing.T) {
+	t.Parallel()
+
+	// transparent images are kept as png by default
+	var filename = writePNG(t, testImage(true))
+	newFile, imageType, err := Convert(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "png", imageType)
+	assert.Equal(t, filename, newFile)
+
+	// or flattened onto the background
+	var options = DefaultConvertOptions
+	options.Alpha = AlphaFlatten
+	options.Background = color.RGBA{R: 0xff, A: 0xff}
+	result, err := ConvertWithOptions(filename, options)
+	assert.NoError(t, err)
+	assert.Equal(t, AlphaFlattened, result.Alpha)
+	assert.Equal(t, FormatJPEG, result.Format)
+
+	var file, openErr = os.Open(result.File)
+	assert.NoError(t, openErr)
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	assert.NoError(t, err)
+	var r, g, b, _ = img.At(16, 16).RGBA()
+	assert.Greater(t, r>>8, uint32(0xf0), "transparent area should be red")
+	assert.Less(t, g>>8, uint32(0x10))
+	assert.Less(t, b>>8, uint32(0x10))
+	r, _, _, _ = img.At(2, 2).RGBA()
+	assert.Less(t, r>>8, uint32(0x10), "opaque area should stay black")
+
+	// an alpha channel that is not used does not count
+	assert.False(t, usesAlpha(testImage(false)))
+	assert.True(t, usesAlpha(testImage(true)))
+}
+
+func readFile(t *testing.T, filename string) []byte {
+	t.Helper()
+
+	var data, err = os.ReadFile(filename)
+	assert.NoError(t, err)
+	return data
+}
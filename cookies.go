@@ -0,0 +1,318 @@
+package imageupsizer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"golang.org/x/net/publicsuffix"
+)
+
+// jar holds the cookies shared by uploadImage's http.Client and every chrome instance started by scrape
+var jar = newCookieJar()
+
+// cookieJar is a minimal http.CookieJar that, unlike net/http/cookiejar,
+// can list everything it holds so the cookies can be handed to chrome and written to disk.
+type cookieJar struct {
+	mu      sync.Mutex
+	entries map[string]*jarEntry
+}
+
+type jarEntry struct {
+	http.Cookie
+	hostOnly bool
+}
+
+func newCookieJar() *cookieJar {
+	return &cookieJar{entries: make(map[string]*jarEntry)}
+}
+
+func jarKey(domain, path, name string) string {
+	return domain + ";" + path + ";" + name
+}
+
+// SetCookies implements http.CookieJar
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var host = strings.ToLower(u.Hostname())
+	for _, c := range cookies {
+		var entry = &jarEntry{Cookie: *c}
+		entry.Domain = strings.TrimPrefix(strings.ToLower(c.Domain), ".")
+		switch {
+		case entry.Domain == "":
+			entry.Domain = host
+			entry.hostOnly = true
+		case !domainMatch(host, entry.Domain):
+			// a site can only set cookies for itself and its parent domains
+			continue
+		case isPublicSuffix(entry.Domain):
+			// and not for every site under a public suffix like com or co.uk, unless it is that suffix itself
+			if host != entry.Domain {
+				continue
+			}
+			entry.hostOnly = true
+		}
+		if entry.Path == "" || !strings.HasPrefix(entry.Path, "/") {
+			entry.Path = "/"
+		}
+		if c.MaxAge > 0 {
+			entry.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		}
+
+		var key = jarKey(entry.Domain, entry.Path, entry.Name)
+		if c.MaxAge < 0 || (!entry.Expires.IsZero() && entry.Expires.Before(time.Now())) {
+			delete(j.entries, key)
+			continue
+		}
+		j.entries[key] = entry
+	}
+}
+
+// Cookies implements http.CookieJar
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var host = strings.ToLower(u.Hostname())
+	var cookies []*http.Cookie
+	for key, entry := range j.entries {
+		if !entry.Expires.IsZero() && entry.Expires.Before(time.Now()) {
+			delete(j.entries, key)
+			continue
+		}
+		if entry.hostOnly && host != entry.Domain {
+			continue
+		}
+		if !entry.hostOnly && !domainMatch(host, entry.Domain) {
+			continue
+		}
+		if !pathMatch(u.EscapedPath(), entry.Path) {
+			continue
+		}
+		if entry.Secure && u.Scheme != "https" {
+			continue
+		}
+		cookies = append(cookies, &http.Cookie{Name: entry.Name, Value: entry.Value})
+	}
+	return cookies
+}
+
+// domainMatch reports whether host is domain or one of its subdomains, as in RFC 6265 5.1.3
+func domainMatch(host, domain string) bool {
+	return host == domain || (strings.HasSuffix(host, "."+domain) && net.ParseIP(host) == nil)
+}
+
+// pathMatch reports whether a cookie with cookiePath is sent to requestPath, as in RFC 6265 5.1.4:
+// /foo matches /foo and /foo/bar but not /foobar
+func pathMatch(requestPath, cookiePath string) bool {
+	if requestPath == "" {
+		requestPath = "/"
+	}
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+	return len(requestPath) == len(cookiePath) || strings.HasSuffix(cookiePath, "/") || requestPath[len(cookiePath)] == '/'
+}
+
+// isPublicSuffix reports whether domain is a suffix under which anyone can register a name, e.g. com or co.uk
+func isPublicSuffix(domain string) bool {
+	var suffix, _ = publicsuffix.PublicSuffix(domain)
+	return suffix == domain
+}
+
+// all returns a copy of every live cookie in the jar.
+func (j *cookieJar) all() []jarEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var entries = make([]jarEntry, 0, len(j.entries))
+	for _, entry := range j.entries {
+		if !entry.Expires.IsZero() && entry.Expires.Before(time.Now()) {
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+// add stores a cookie exactly as given, used when importing from a file or from chrome.
+func (j *cookieJar) add(entry jarEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if entry.Path == "" {
+		entry.Path = "/"
+	}
+	j.entries[jarKey(entry.Domain, entry.Path, entry.Name)] = &entry
+}
+
+// LoadCookies imports cookies from a Netscape formatted cookies.txt file
+// (the format written by curl and most browser export extensions).
+// A file that does not exist is not an error so the same path can be
+// given to LoadCookies and SaveCookies to persist a session across runs.
+func LoadCookies(filename string) error {
+	return jar.load(filename)
+}
+
+func (j *cookieJar) load(filename string) error {
+	var file, err = os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error opening cookie file: %s, error: %w", filename, err)
+	}
+	defer file.Close()
+
+	var scanner = bufio.NewScanner(file)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		var line = strings.TrimSpace(scanner.Text())
+
+		var httpOnly bool
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+			httpOnly = true
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var fields = strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("malformed cookie file: %s, line: %d", filename, lineNum)
+		}
+
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("malformed cookie expiry: %s, line: %d, error: %w", filename, lineNum, err)
+		}
+
+		var entry = jarEntry{
+			Cookie: http.Cookie{
+				Domain:   strings.TrimPrefix(strings.ToLower(fields[0]), "."),
+				Path:     fields[2],
+				Secure:   strings.EqualFold(fields[3], "TRUE"),
+				Name:     fields[5],
+				Value:    fields[6],
+				HttpOnly: httpOnly,
+			},
+			hostOnly: !strings.EqualFold(fields[1], "TRUE"),
+		}
+		if expires > 0 {
+			entry.Expires = time.Unix(expires, 0)
+		}
+		j.add(entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading cookie file: %s, error: %w", filename, err)
+	}
+	return nil
+}
+
+// SaveCookies writes every cookie collected so far to filename in Netscape cookies.txt format.
+func SaveCookies(filename string) error {
+	return jar.save(filename)
+}
+
+func (j *cookieJar) save(filename string) error {
+	var buf strings.Builder
+	buf.WriteString("# Netscape HTTP Cookie File\n")
+
+	for _, entry := range j.all() {
+		var domain = entry.Domain
+		var includeSubdomains = "FALSE"
+		if !entry.hostOnly {
+			domain = "." + domain
+			includeSubdomains = "TRUE"
+		}
+		if entry.HttpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var secure = "FALSE"
+		if entry.Secure {
+			secure = "TRUE"
+		}
+		var expires int64
+		if !entry.Expires.IsZero() {
+			expires = entry.Expires.Unix()
+		}
+		fmt.Fprintf(&buf, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, includeSubdomains, entry.Path, secure, expires, entry.Name, entry.Value)
+	}
+
+	if err := os.WriteFile(filename, []byte(buf.String()), 0600); err != nil {
+		return fmt.Errorf("error writing cookie file: %s, error: %w", filename, err)
+	}
+	return nil
+}
+
+// setChromeCookies copies the jar into a freshly started chrome instance.
+func setChromeCookies(ctx context.Context) error {
+	var entries = jar.all()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var params = make([]*network.CookieParam, 0, len(entries))
+	for _, entry := range entries {
+		var param = &network.CookieParam{
+			Name:     entry.Name,
+			Value:    entry.Value,
+			Path:     entry.Path,
+			Secure:   entry.Secure,
+			HTTPOnly: entry.HttpOnly,
+		}
+		if entry.hostOnly {
+			param.URL = "https://" + entry.Domain + entry.Path
+		} else {
+			param.Domain = "." + entry.Domain
+		}
+		if !entry.Expires.IsZero() {
+			var expires = cdp.TimeSinceEpoch(entry.Expires)
+			param.Expires = &expires
+		}
+		params = append(params, param)
+	}
+
+	return network.SetCookies(params).Do(ctx)
+}
+
+// getChromeCookies copies everything chrome has collected for the given urls back into the jar.
+func getChromeCookies(ctx context.Context, urls ...string) error {
+	var cookies, err = network.GetCookies().WithUrls(urls).Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range cookies {
+		var entry = jarEntry{
+			Cookie: http.Cookie{
+				Name:     c.Name,
+				Value:    c.Value,
+				Domain:   strings.TrimPrefix(c.Domain, "."),
+				Path:     c.Path,
+				Secure:   c.Secure,
+				HttpOnly: c.HTTPOnly,
+			},
+			hostOnly: !strings.HasPrefix(c.Domain, "."),
+		}
+		if !c.Session && c.Expires > 0 {
+			entry.Expires = time.Unix(int64(c.Expires), 0)
+		}
+		jar.add(entry)
+	}
+	return nil
+}
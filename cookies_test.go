@@ -0,0 +1,110 @@
+package imageupsizer
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieJarMatching(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		set    string
+		cookie *http.Cookie
+		get    string
+		sent   bool
+	}{
+		// host only cookies go to the host and nothing else
+		{"https://www.google.com/", &http.Cookie{Name: "a"}, "https://www.google.com/search", true},
+		{"https://www.google.com/", &http.Cookie{Name: "a"}, "https://lens.google.com/", false},
+		// domain cookies go to the domain and its subdomains, but not to names that merely end the same
+		{"https://www.google.com/", &http.Cookie{Name: "a", Domain: ".google.com"}, "https://lens.google.com/", true},
+		{"https://www.google.com/", &http.Cookie{Name: "a", Domain: "google.com"}, "https://google.com/", true},
+		{"https://www.google.com/", &http.Cookie{Name: "a", Domain: "google.com"}, "https://evilgoogle.com/", false},
+		// a site can't set cookies for another site or a public suffix
+		{"https://www.google.com/", &http.Cookie{Name: "a", Domain: "example.com"}, "https://example.com/", false},
+		{"https://www.google.com/", &http.Cookie{Name: "a", Domain: "com"}, "https://example.com/", false},
+		{"https://www.google.co.uk/", &http.Cookie{Name: "a", Domain: "co.uk"}, "https://bbc.co.uk/", false},
+		{"https://www.google.co.uk/", &http.Cookie{Name: "a", Domain: "google.co.uk"}, "https://lens.google.co.uk/", true},
+		// paths match on whole segments
+		{"https://google.com/", &http.Cookie{Name: "a", Path: "/foo"}, "https://google.com/foo", true},
+		{"https://google.com/", &http.Cookie{Name: "a", Path: "/foo"}, "https://google.com/foo/bar", true},
+		{"https://google.com/", &http.Cookie{Name: "a", Path: "/foo"}, "https://google.com/foobar", false},
+		{"https://google.com/", &http.Cookie{Name: "a", Path: "/foo/"}, "https://google.com/foo/bar", true},
+		{"https://google.com/", &http.Cookie{Name: "a", Path: "/foo/"}, "https://google.com/foobar", false},
+		{"https://google.com/", &http.Cookie{Name: "a", Path: "/foo/"}, "https://google.com/foo", false},
+		{"https://google.com/", &http.Cookie{Name: "a", Path: "/"}, "https://google.com", true},
+		// secure cookies only go over https, expired ones nowhere
+		{"https://google.com/", &http.Cookie{Name: "a", Secure: true}, "http://google.com/", false},
+		{"https://google.com/", &http.Cookie{Name: "a", MaxAge: -1}, "https://google.com/", false},
+		{"https://google.com/", &http.Cookie{Name: "a", Expires: time.Now().Add(-time.Hour)}, "https://google.com/", false},
+	}
+
+	for _, test := range tests {
+		var j = newCookieJar()
+		var set, err = url.Parse(test.set)
+		require.NoError(t, err)
+		get, err := url.Parse(test.get)
+		require.NoError(t, err)
+
+		test.cookie.Value = "1"
+		j.SetCookies(set, []*http.Cookie{test.cookie})
+		var name = test.set + " " + test.cookie.String() + " -> " + test.get
+		if test.sent {
+			assert.Equal(t, []*http.Cookie{{Name: "a", Value: "1"}}, j.Cookies(get), name)
+		} else {
+			assert.Empty(t, j.Cookies(get), name)
+		}
+	}
+}
+
+func TestCookieFile(t *testing.T) {
+	t.Parallel()
+
+	var expires = strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10)
+	var lines = []string{
+		".google.com\tTRUE\t/\tTRUE\t" + expires + "\tSOCS\tCAISHAgBEhJnd3NfMjAyNDA1MTMtMF9SQzIaAmVuIAEaBgiA8Ye0Bg",
+		"#HttpOnly_.google.com\tTRUE\t/\tTRUE\t" + expires + "\tNID\t514=abc",
+		"lens.google.com\tFALSE\t/upload\tFALSE\t0\tsession\tvalue",
+	}
+	var dir = t.TempDir()
+	var filename = filepath.Join(dir, "cookies.txt")
+	// comments, blank lines and long expired cookies are skipped
+	var contents = "# Netscape HTTP Cookie File\n\n" + strings.Join(lines, "\n") + "\n.google.com\tTRUE\t/\tFALSE\t1\told\tgone\n"
+	require.NoError(t, os.WriteFile(filename, []byte(contents), 0600))
+
+	var j = newCookieJar()
+	require.NoError(t, j.load(filename))
+	var upload, err = url.Parse("https://lens.google.com/upload?re=df")
+	require.NoError(t, err)
+	var names []string
+	for _, c := range j.Cookies(upload) {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"NID", "SOCS", "session"}, names)
+
+	// what is saved loads back the same
+	var saved = filepath.Join(dir, "saved.txt")
+	require.NoError(t, j.save(saved))
+	written, err := os.ReadFile(saved)
+	require.NoError(t, err)
+	var got = strings.Split(strings.TrimSpace(string(written)), "\n")
+	assert.Equal(t, "# Netscape HTTP Cookie File", got[0])
+	assert.ElementsMatch(t, lines, got[1:])
+
+	// a missing file is an empty jar, a broken one is an error
+	assert.NoError(t, newCookieJar().load(filepath.Join(dir, "missing.txt")))
+	require.NoError(t, os.WriteFile(filename, []byte("google.com\tTRUE\t/\n"), 0600))
+	assert.Error(t, newCookieJar().load(filename))
+}
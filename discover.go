@@ -0,0 +1,100 @@
+package imageupsizer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLength is enough of a file to match every magic header in Formats
+const sniffLength = 32
+
+// systemNames are files and directories that operating systems and NAS boxes leave next to photos
+var systemNames = map[string]bool{
+	"thumbs.db":                 true,
+	"ehthumbs.db":               true,
+	"desktop.ini":               true,
+	"__macosx":                  true,
+	"@eadir":                    true,
+	"$recycle.bin":              true,
+	"system volume information": true,
+}
+
+// Discovered is what DiscoverImages found.
+type Discovered struct {
+	// Images are the files in one of the Formats, whatever their extension
+	Images []string
+	// Formats is the format of each of the Images, by file name
+	Formats map[string]string
+	// Mislabeled are the Images whose extension belongs to another format
+	Mislabeled []string
+	// Unclassified are the files with an image extension that are none of the Formats, or could not be read
+	Unclassified map[string]error
+	// Skipped are the hidden and system files
+	Skipped []string
+}
+
+// DiscoverImages finds the images among files by their contents, not their names. Files under root
+// whose name, or the name of a directory between them and root, starts with a dot or is a system file are skipped.
+func DiscoverImages(root string, files []string) Discovered {
+	var discovered = Discovered{Formats: make(map[string]string), Unclassified: make(map[string]error)}
+
+	for _, filename := range files {
+		if isHidden(root, filename) {
+			discovered.Skipped = append(discovered.Skipped, filename)
+			continue
+		}
+
+		var extensionFormat = formatOfExtension(filepath.Ext(filename))
+		var format, err = SniffFormat(filename)
+		switch {
+		case err != nil:
+			discovered.Unclassified[filename] = err
+		case format != "":
+			discovered.Images = append(discovered.Images, filename)
+			discovered.Formats[filename] = format
+			if extensionFormat != "" && extensionFormat != format {
+				discovered.Mislabeled = append(discovered.Mislabeled, filename)
+			}
+		case extensionFormat != "":
+			discovered.Unclassified[filename] = fmt.Errorf("not a %s image", extensionFormat)
+		}
+		// everything else is some other kind of file, e.g. a sidecar
+	}
+	return discovered
+}
+
+// SniffFormat is the name of the format of filename from its first bytes, empty when it's none of the Formats.
+func SniffFormat(filename string) (string, error) {
+	var file, err = os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("error opening file: %s, error: %w", filename, err)
+	}
+	defer file.Close()
+
+	var header = make([]byte, sniffLength)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("error reading file: %s, error: %w", filename, err)
+	}
+	return sniffFormat(header[:n]), nil
+}
+
+// isHidden reports whether filename, or a directory between it and root, is hidden or a system file
+func isHidden(root, filename string) bool {
+	var rel, err = filepath.Rel(root, filename)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(filename)
+	}
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if name == "." {
+			continue
+		}
+		if strings.HasPrefix(name, ".") || systemNames[strings.ToLower(name)] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package imageupsizer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoverImages(t *testing.T) {
+	t.Parallel()
+
+	var jpegData = encodeJPEG(t, testImage(false), 90).Bytes
+	var pngData = readFile(t, writePNG(t, testImage(false)))
+
+	var dir = t.TempDir()
+	var files = map[string][]byte{
+		"IMG_001.JPG":           jpegData,
+		"mislabeled.png":        jpegData,
+		"no-extension":          pngData,
+		"album/photo.jpeg":      jpegData,
+		"broken.jpg":            []byte("not an image"),
+		"notes.txt":             []byte("notes"),
+		"photo.jpg.json":        []byte("{}"),
+		".hidden.jpg":           jpegData,
+		".thumbnails/a.png":     pngData,
+		"@eaDir/photo.jpg":      jpegData,
+		"album/Thumbs.db":       []byte("thumbs"),
+		"album/heic/photo.HEIC": testHEIF("heic"),
+	}
+	var names []string
+	for name, data := range files {
+		var filename = filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0700))
+		assert.NoError(t, os.WriteFile(filename, data, 0600))
+		names = append(names, filename)
+	}
+	names = append(names, filepath.Join(dir, "deleted.jpg"))
+
+	var discovered = DiscoverImages(dir, names)
+
+	var join = func(names ...string) []string {
+		var joined []string
+		for _, name := range names {
+			joined = append(joined, filepath.Join(dir, name))
+		}
+		return joined
+	}
+	assert.ElementsMatch(t, join("IMG_001.JPG", "mislabeled.png", "no-extension", "album/photo.jpeg", "album/heic/photo.HEIC"), discovered.Images)
+	assert.Equal(t, "png", discovered.Formats[filepath.Join(dir, "no-extension")])
+	assert.Equal(t, "heic", discovered.Formats[filepath.Join(dir, "album/heic/photo.HEIC")])
+	assert.Equal(t, join("mislabeled.png"), discovered.Mislabeled)
+	assert.ElementsMatch(t, join(".hidden.jpg", ".thumbnails/a.png", "@eaDir/photo.jpg", "album/Thumbs.db"), discovered.Skipped)
+
+	assert.Len(t, discovered.Unclassified, 2)
+	assert.ErrorIs(t, discovered.Unclassified[filepath.Join(dir, "deleted.jpg")], os.ErrNotExist)
+	assert.EqualError(t, discovered.Unclassified[filepath.Join(dir, "broken.jpg")], "not a jpeg image")
+}
@@ -8,8 +8,6 @@ import (
 	"github.com/kmulvey/concurrenthash"
 )
 
-var ch concurrenthash.ConcurrentHash
-
 var hashes = map[string]struct{}{
 	"e663f9122d24f60aade166046334e60b1e195ad95a8946227e8c03cfd14031684a2f7acdcfa7322f96650259f79791c661e9b7e006735958f019c081c43bc128": {},
 	"306961ff9f3c040d28bea9dfde979561efc3296999b17648fede6c7dcf9f92f0c1c79d300eb5a65a861590d6329382cf45d1666574aba2b63047fa8db14f99c8": {},
@@ -24,12 +22,15 @@ var hashes = map[string]struct{}{
 	"9be435b5e6339b7c386e840453587576cc00d631fc4197497614f664bc04af91bcafb9473297dae72e75565192af228adb701414d72df6a1451973a2100a5a46": {},
 }
 
-func init() {
-	ch = concurrenthash.NewConcurrentHash(2, 2, sha512.New)
+// newHasher returns the hasher for the error images and the cache keys, the hashes above were taken with it.
+// A ConcurrentHash keeps the block hashes of the file it is working on so it can't be shared by the workers.
+func newHasher() concurrenthash.ConcurrentHash {
+	return concurrenthash.NewConcurrentHash(2, 2, sha512.New)
 }
 
 func isErrorImage(img *ImageData) (bool, error) {
 
+	var ch = newHasher()
 	var hash, err = ch.HashFile(context.Background(), img.LocalPath)
 	if err != nil {
 		return false, fmt.Errorf("error hasing file: %w", err)
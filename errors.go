@@ -1,9 +1,68 @@
 package imageupsizer
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"image"
+	"io/fs"
+	"net"
+	"net/url"
+)
 
 var (
 	ErrNoLargerAvailable = errors.New("there is no large image")
 	ErrCaptcha           = errors.New("response was captcha page")
 	ErrNoResults         = errors.New("no images found")
+	ErrParse             = errors.New("unexpected page layout")
+	ErrConsent           = errors.New("could not accept google consent page")
+	ErrBelowThreshold    = errors.New("larger image is not large enough")
+	ErrSuspicious        = errors.New("larger image is suspicious")
+)
+
+// Error classes returned by ErrorClass
+const (
+	ClassNoLarger = "no-larger"
+	ClassCaptcha  = "captcha"
+	ClassConsent  = "consent"
+	ClassParse    = "parse"
+	ClassNetwork  = "network"
+	ClassDecode   = "decode"
+	ClassFile     = "file"
+	ClassOther    = "other"
 )
+
+// ErrorClass groups the errors returned by this package into a handful of classes
+// that are stable enough to be stored and compared, e.g. to decide what to retry.
+// It returns "" for a nil error.
+func ErrorClass(err error) string {
+	var netErr net.Error
+	var urlErr *url.Error
+	var pathErr *fs.PathError
+
+	switch {
+	case err == nil:
+		return ""
+	case isNegative(err):
+		return ClassNoLarger
+	case errors.Is(err, ErrCaptcha):
+		return ClassCaptcha
+	case errors.Is(err, ErrConsent):
+		return ClassConsent
+	case errors.Is(err, ErrParse):
+		return ClassParse
+	case errors.As(err, &urlErr), errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		return ClassNetwork
+	case errors.Is(err, image.ErrFormat):
+		return ClassDecode
+	case errors.As(err, &pathErr):
+		return ClassFile
+	default:
+		return ClassOther
+	}
+}
+
+// isNegative reports whether err means the search worked but there is nothing larger,
+// as opposed to the search failing.
+func isNegative(err error) bool {
+	return errors.Is(err, ErrNoLargerAvailable) || errors.Is(err, ErrNoResults) || errors.Is(err, ErrBelowThreshold) || errors.Is(err, ErrSuspicious) || errors.Is(err, OtherSizesNotAvailableError) || errors.Is(err, NoMatchesError)
+}
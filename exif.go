@@ -0,0 +1,289 @@
+package imageupsizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// errBadEXIF is returned for exif that is not a valid tiff structure: a header
+// followed by IFDs, each a list of tagged values
+var errBadEXIF = errors.New("malformed exif")
+
+// exif tags that point to other IFDs
+const (
+	tagExifIFD = 0x8769
+	tagGPSIFD  = 0x8825
+)
+
+// safeIFD0Tags, safeExifTags and safeGPSTags are the tags the safe metadata subset copies:
+// when and with what the photo was taken and where. Everything describing the file itself,
+// dimensions, thumbnails, maker notes, goes stale when it's copied to another file.
+var safeIFD0Tags = map[uint16]bool{
+	0x010E: true, // ImageDescription
+	0x010F: true, // Make
+	0x0110: true, // Model
+	0x0132: true, // DateTime
+	0x013B: true, // Artist
+	0x8298: true, // Copyright
+}
+
+var safeExifTags = map[uint16]bool{
+	0x829A: true, // ExposureTime
+	0x829D: true, // FNumber
+	0x8822: true, // ExposureProgram
+	0x8827: true, // ISOSpeedRatings
+	0x9003: true, // DateTimeOriginal
+	0x9004: true, // DateTimeDigitized
+	0x9010: true, // OffsetTime
+	0x9011: true, // OffsetTimeOriginal
+	0x9012: true, // OffsetTimeDigitized
+	0x9201: true, // ShutterSpeedValue
+	0x9202: true, // ApertureValue
+	0x9204: true, // ExposureBiasValue
+	0x9207: true, // MeteringMode
+	0x9209: true, // Flash
+	0x920A: true, // FocalLength
+	0x9290: true, // SubSecTime
+	0x9291: true, // SubSecTimeOriginal
+	0x9292: true, // SubSecTimeDigitized
+	0xA405: true, // FocalLengthIn35mmFilm
+	0xA430: true, // CameraOwnerName
+	0xA431: true, // BodySerialNumber
+	0xA432: true, // LensSpecification
+	0xA433: true, // LensMake
+	0xA434: true, // LensModel
+}
+
+// safeGPSTags is nil because every gps tag is kept
+var safeGPSTags map[uint16]bool
+
+// exifEntry is one tagged value, value is the raw bytes in the file's byte order
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// exifData is a parsed exif block, only the IFDs the safe subset needs are kept
+type exifData struct {
+	order binary.ByteOrder
+	ifd0  []exifEntry
+	exif  []exifEntry
+	gps   []exifEntry
+}
+
+// exifTypeSizes is the size of one value of each tiff type
+var exifTypeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// parseEXIF parses the tiff structure of an exif block
+func parseEXIF(data []byte) (*exifData, error) {
+	if len(data) < 8 {
+		return nil, errBadEXIF
+	}
+
+	var e = new(exifData)
+	switch string(data[:4]) {
+	case "II*\x00":
+		e.order = binary.LittleEndian
+	case "MM\x00*":
+		e.order = binary.BigEndian
+	default:
+		return nil, errBadEXIF
+	}
+
+	var err error
+	e.ifd0, err = e.readIFD(data, e.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range e.ifd0 {
+		if entry.tag != tagExifIFD && entry.tag != tagGPSIFD {
+			continue
+		}
+		if len(entry.value) < 4 {
+			return nil, errBadEXIF
+		}
+		entries, err := e.readIFD(data, e.order.Uint32(entry.value))
+		if err != nil {
+			return nil, err
+		}
+		if entry.tag == tagExifIFD {
+			e.exif = entries
+		} else {
+			e.gps = entries
+		}
+	}
+	return e, nil
+}
+
+// readIFD reads the entries of the IFD at offset
+func (e *exifData) readIFD(data []byte, offset uint32) ([]exifEntry, error) {
+	if uint64(offset)+2 > uint64(len(data)) {
+		return nil, errBadEXIF
+	}
+	var count = int(e.order.Uint16(data[offset:]))
+	var start = int(offset) + 2
+	if start+count*12 > len(data) {
+		return nil, errBadEXIF
+	}
+
+	var entries = make([]exifEntry, 0, count)
+	for i := 0; i < count; i++ {
+		var raw = data[start+i*12 : start+(i+1)*12]
+		var entry = exifEntry{
+			tag:   e.order.Uint16(raw),
+			typ:   e.order.Uint16(raw[2:]),
+			count: e.order.Uint32(raw[4:]),
+		}
+		var size, ok = exifTypeSizes[entry.typ]
+		if !ok {
+			// unknown types can't be copied, their size is not known
+			continue
+		}
+		var length = uint64(size) * uint64(entry.count)
+		if length <= 4 {
+			entry.value = raw[8 : 8+length]
+		} else {
+			var valueOffset = uint64(e.order.Uint32(raw[8:]))
+			if valueOffset+length > uint64(len(data)) {
+				return nil, errBadEXIF
+			}
+			entry.value = data[valueOffset : valueOffset+length]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// filterEntries keeps the tags in keep, all of them when keep is nil
+func filterEntries(entries []exifEntry, keep map[uint16]bool) []exifEntry {
+	var kept []exifEntry
+	for _, entry := range entries {
+		if keep == nil || keep[entry.tag] {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// safe is a copy with only the tags in the safe subset
+func (e *exifData) safe() *exifData {
+	return &exifData{
+		order: e.order,
+		ifd0:  filterEntries(e.ifd0, safeIFD0Tags),
+		exif:  filterEntries(e.exif, safeExifTags),
+		gps:   filterEntries(e.gps, safeGPSTags),
+	}
+}
+
+// encode writes the exif block: the header, IFD0, the exif and gps IFDs, then the values that don't fit in an entry
+func (e *exifData) encode() []byte {
+	var ifd0 = filterEntries(e.ifd0, nil)
+	// the pointers are rewritten below
+	ifd0 = removeTags(ifd0, tagExifIFD, tagGPSIFD)
+	if len(e.exif) > 0 {
+		ifd0 = append(ifd0, exifEntry{tag: tagExifIFD, typ: 4, count: 1, value: make([]byte, 4)})
+	}
+	if len(e.gps) > 0 {
+		ifd0 = append(ifd0, exifEntry{tag: tagGPSIFD, typ: 4, count: 1, value: make([]byte, 4)})
+	}
+
+	var ifds = [][]exifEntry{ifd0}
+	if len(e.exif) > 0 {
+		ifds = append(ifds, e.exif)
+	}
+	if len(e.gps) > 0 {
+		ifds = append(ifds, e.gps)
+	}
+
+	// lay out every IFD after the header, then all the values that are too large to be inline
+	var offsets = make([]uint32, len(ifds))
+	var offset = uint32(8)
+	for i, entries := range ifds {
+		offsets[i] = offset
+		offset += 2 + uint32(len(entries))*12 + 4
+	}
+	var dataOffset = offset
+
+	var buf bytes.Buffer
+	var data bytes.Buffer
+	if e.order == binary.LittleEndian {
+		buf.WriteString("II*\x00")
+	} else {
+		buf.WriteString("MM\x00*")
+	}
+	_ = binary.Write(&buf, e.order, uint32(8))
+
+	for i, entries := range ifds {
+		// tiff wants the entries sorted by tag
+		sort.Slice(entries, func(a, b int) bool { return entries[a].tag < entries[b].tag })
+
+		_ = binary.Write(&buf, e.order, uint16(len(entries)))
+		for _, entry := range entries {
+			var value = entry.value
+			switch {
+			case i == 0 && entry.tag == tagExifIFD:
+				value = make([]byte, 4)
+				e.order.PutUint32(value, offsets[1])
+			case i == 0 && entry.tag == tagGPSIFD:
+				value = make([]byte, 4)
+				e.order.PutUint32(value, offsets[len(offsets)-1])
+			}
+
+			_ = binary.Write(&buf, e.order, entry.tag)
+			_ = binary.Write(&buf, e.order, entry.typ)
+			_ = binary.Write(&buf, e.order, entry.count)
+			if len(value) <= 4 {
+				var inline [4]byte
+				copy(inline[:], value)
+				buf.Write(inline[:])
+				continue
+			}
+			_ = binary.Write(&buf, e.order, dataOffset+uint32(data.Len()))
+			data.Write(value)
+			// values start on word boundaries
+			if data.Len()%2 == 1 {
+				data.WriteByte(0)
+			}
+		}
+		// no next IFD, thumbnails live in IFD1 and are not copied
+		_ = binary.Write(&buf, e.order, uint32(0))
+	}
+
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
+
+func removeTags(entries []exifEntry, tags ...uint16) []exifEntry {
+	var kept = entries[:0:0]
+	for _, entry := range entries {
+		var remove bool
+		for _, tag := range tags {
+			remove = remove || entry.tag == tag
+		}
+		if !remove {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// safeEXIF returns the safe subset of an exif block, nil when none of it is left
+func safeEXIF(data []byte) ([]byte, error) {
+	var parsed, err = parseEXIF(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing exif: %w", err)
+	}
+	var safe = parsed.safe()
+	if len(safe.ifd0) == 0 && len(safe.exif) == 0 && len(safe.gps) == 0 {
+		return nil, nil
+	}
+	return safe.encode(), nil
+}
@@ -0,0 +1,81 @@
+package imageupsizer
+
+import (
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// ImageFormat is an image format this package can read, Name is what image.Decode calls it.
+type ImageFormat struct {
+	Name       string
+	Extensions []string
+	// Magic are the first bytes of files in this format, ? matches any byte
+	Magic []string
+	// SizeOnly formats can be compared and searched for, but not decoded, see ErrNoDecoder
+	SizeOnly bool
+}
+
+// Formats are the formats registered with the image package, gifs are read as their first frame.
+var Formats = []ImageFormat{
+	{Name: "jpeg", Extensions: []string{".jpg", ".jpeg", ".jpe", ".jfif"}, Magic: []string{"\xff\xd8"}},
+	{Name: "png", Extensions: []string{".png"}, Magic: []string{"\x89PNG\r\n\x1a\n"}},
+	{Name: "webp", Extensions: []string{".webp"}, Magic: []string{"RIFF????WEBPVP8"}},
+	{Name: "gif", Extensions: []string{".gif"}, Magic: []string{"GIF87a", "GIF89a"}},
+	{Name: "bmp", Extensions: []string{".bmp"}, Magic: []string{"BM????\x00\x00\x00\x00"}},
+	{Name: "tiff", Extensions: []string{".tif", ".tiff"}, Magic: []string{"II*\x00", "MM\x00*"}},
+	{Name: "avif", Extensions: []string{".avif"}, SizeOnly: true, Magic: []string{"????ftypavif", "????ftypavis"}},
+	{Name: "heic", Extensions: []string{".heic", ".heif"}, SizeOnly: true,
+		Magic: []string{"????ftypheic", "????ftypheix", "????ftypheim", "????ftypheis", "????ftyphevc", "????ftyphevx", "????ftypmif1", "????ftypmsf1"}},
+}
+
+// Extensions are the file extensions of every format in Formats
+func Extensions() []string {
+	var extensions []string
+	for _, format := range Formats {
+		extensions = append(extensions, format.Extensions...)
+	}
+	return extensions
+}
+
+// formatOfExtension is the name of the format ext belongs to, case insensitive, empty when it's none of them
+func formatOfExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	for _, format := range Formats {
+		for _, known := range format.Extensions {
+			if ext == known {
+				return format.Name
+			}
+		}
+	}
+	return ""
+}
+
+// sniffFormat is the name of the format whose magic header starts data, empty when it's none of them
+func sniffFormat(data []byte) string {
+	for _, format := range Formats {
+		for _, magic := range format.Magic {
+			if matchMagic(data, magic) {
+				return format.Name
+			}
+		}
+	}
+	return ""
+}
+
+func matchMagic(data []byte, magic string) bool {
+	if len(data) < len(magic) {
+		return false
+	}
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && magic[i] != data[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package imageupsizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// box builds an iso bmff box
+func box(typ string, contents ...[]byte) []byte {
+	var data = bytes.Join(contents, nil)
+	var header = make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(8+len(data)))
+	copy(header[4:], typ)
+	return append(header, data...)
+}
+
+// testHEIF is a heif file whose primary item 1 is 4032x3024 turned by 90 degrees, item 2 is a larger image that is not the primary one
+func testHEIF(brand string) []byte {
+	var ispe = func(width, height uint32) []byte {
+		var data = make([]byte, 12)
+		binary.BigEndian.PutUint32(data[4:], width)
+		binary.BigEndian.PutUint32(data[8:], height)
+		return box("ispe", data)
+	}
+	var ipco = box("ipco", ispe(8064, 6048), ispe(4032, 3024), box("irot", []byte{1}))
+	// version 0, flags 0, two items: 1 has properties 2 and 3, 2 has property 1
+	var ipma = box("ipma", []byte{0, 0, 0, 0, 0, 0, 0, 2, 0, 1, 2, 0x82, 3, 0, 2, 1, 0x81})
+	var pitm = box("pitm", []byte{0, 0, 0, 0, 0, 1})
+	var meta = box("meta", []byte{0, 0, 0, 0}, pitm, box("iprp", ipco, ipma))
+	return append(box("ftyp", []byte(brand), make([]byte, 4), []byte("mif1")), append(meta, box("mdat")...)...)
+}
+
+func TestHEIFConfig(t *testing.T) {
+	t.Parallel()
+
+	for brand, name := range map[string]string{"avif": "avif", "heic": "heic", "mif1": "heic"} {
+		var config, format, err = image.DecodeConfig(bytes.NewReader(testHEIF(brand)))
+		assert.NoError(t, err, brand)
+		assert.Equal(t, name, format)
+		assert.Equal(t, 3024, config.Width, brand)
+		assert.Equal(t, 4032, config.Height, brand)
+
+		_, _, err = image.Decode(bytes.NewReader(testHEIF(brand)))
+		assert.ErrorIs(t, err, ErrNoDecoder)
+	}
+
+	var _, _, err = image.DecodeConfig(bytes.NewReader(box("ftyp", []byte("avif"), make([]byte, 4))))
+	assert.ErrorIs(t, err, errBadHEIF)
+
+	// there is nothing to convert them to, the file is kept
+	var filename = filepath.Join(t.TempDir(), "photo.heic")
+	assert.NoError(t, os.WriteFile(filename, testHEIF("heic"), 0600))
+	result, err := ConvertWithOptions(filename, DefaultConvertOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, filename, result.File)
+	assert.Equal(t, Format("heic"), result.Format)
+}
+
+func FuzzHEIFConfig(f *testing.F) {
+	f.Add(testHEIF("heic"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = heifConfig(bytes.NewReader(data))
+	})
+}
+
+func TestFormats(t *testing.T) {
+	t.Parallel()
+
+	var img = image.NewPaletted(image.Rect(0, 0, 24, 16), palette.Plan9)
+	var encoders = map[string]func(*bytes.Buffer) error{
+		"gif":  func(buf *bytes.Buffer) error { return gif.Encode(buf, img, nil) },
+		"bmp":  func(buf *bytes.Buffer) error { return bmp.Encode(buf, img) },
+		"tiff": func(buf *bytes.Buffer) error { return tiff.Encode(buf, img, nil) },
+	}
+	for name, encode := range encoders {
+		var buf bytes.Buffer
+		assert.NoError(t, encode(&buf))
+		var decoded, format, err = image.Decode(&buf)
+		assert.NoError(t, err, name)
+		assert.Equal(t, name, format)
+		assert.Equal(t, img.Bounds(), decoded.Bounds(), name)
+	}
+
+	for _, name := range []string{"a.jpg", "a.jpeg", "b/c.png", "a.webp", "a.gif", "a.bmp", "a.tif", "a.tiff", "a.avif", "a.heic", "a.heif", "IMG_001.JPG", "a.Png"} {
+		assert.NotEmpty(t, formatOfExtension(filepath.Ext(name)), name)
+	}
+	for _, name := range []string{"a.txt", "ajpg", "a.jpg.json", "a.mp4"} {
+		assert.Empty(t, formatOfExtension(filepath.Ext(name)), name)
+	}
+}
@@ -0,0 +1,238 @@
+package imageupsizer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// ErrNoDecoder is returned when decoding avif or heic images, there is no pure go av1 or hevc
+// decoder. Their size can still be read with image.DecodeConfig.
+var ErrNoDecoder = errors.New("no decoder for this image codec, only its size can be read")
+
+// errBadHEIF is returned for avif and heic files whose boxes can't be parsed
+var errBadHEIF = errors.New("malformed heif")
+
+func init() {
+	for _, format := range Formats {
+		if format.Name != "avif" && format.Name != "heic" {
+			continue
+		}
+		for _, magic := range format.Magic {
+			image.RegisterFormat(format.Name, magic, decodeHEIF, heifConfig)
+		}
+	}
+}
+
+func decodeHEIF(io.Reader) (image.Image, error) {
+	return nil, ErrNoDecoder
+}
+
+// bmffBox is a box of the iso base media file format avif and heic are stored in
+type bmffBox struct {
+	typ  string
+	data []byte
+}
+
+// readBoxes splits data into boxes
+func readBoxes(data []byte) ([]bmffBox, error) {
+	var boxes []bmffBox
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errBadHEIF
+		}
+		var size = uint64(binary.BigEndian.Uint32(data))
+		var typ = string(data[4:8])
+		var header = uint64(8)
+		switch size {
+		case 0:
+			// the box runs to the end
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errBadHEIF
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, errBadHEIF
+		}
+		boxes = append(boxes, bmffBox{typ: typ, data: data[header:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// findBox returns the first box of type typ
+func findBox(boxes []bmffBox, typ string) (bmffBox, bool) {
+	for _, box := range boxes {
+		if box.typ == typ {
+			return box, true
+		}
+	}
+	return bmffBox{}, false
+}
+
+// heifConfig reads the size of the primary image: the ispe property of the item pitm names,
+// turned by its irot property. Phones store portrait photos as landscape and rotate them with irot.
+func heifConfig(r io.Reader) (image.Config, error) {
+	var data, err = io.ReadAll(r)
+	if err != nil {
+		return image.Config{}, fmt.Errorf("error reading heif: %w", err)
+	}
+
+	top, err := readBoxes(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+	meta, ok := findBox(top, "meta")
+	// meta is a full box, it starts with its version and flags
+	if !ok || len(meta.data) < 4 {
+		return image.Config{}, errBadHEIF
+	}
+	metaBoxes, err := readBoxes(meta.data[4:])
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	iprp, ok := findBox(metaBoxes, "iprp")
+	if !ok {
+		return image.Config{}, errBadHEIF
+	}
+	iprpBoxes, err := readBoxes(iprp.data)
+	if err != nil {
+		return image.Config{}, err
+	}
+	ipco, ok := findBox(iprpBoxes, "ipco")
+	if !ok {
+		return image.Config{}, errBadHEIF
+	}
+	properties, err := readBoxes(ipco.data)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	// the properties of the primary item, every property when that can't be worked out
+	var indexes []int
+	if pitm, ok := findBox(metaBoxes, "pitm"); ok {
+		if ipma, ok := findBox(iprpBoxes, "ipma"); ok {
+			if primary, ok := primaryItem(pitm.data); ok {
+				indexes = itemProperties(ipma.data, primary)
+			}
+		}
+	}
+	if indexes == nil {
+		for i := range properties {
+			indexes = append(indexes, i+1)
+		}
+	}
+
+	var config = image.Config{ColorModel: color.NRGBAModel}
+	var rotation int
+	for _, index := range indexes {
+		if index < 1 || index > len(properties) {
+			continue
+		}
+		var property = properties[index-1]
+		switch property.typ {
+		case "ispe":
+			// version and flags, then the width and height
+			if len(property.data) < 12 {
+				return image.Config{}, errBadHEIF
+			}
+			var width = int(binary.BigEndian.Uint32(property.data[4:]))
+			var height = int(binary.BigEndian.Uint32(property.data[8:]))
+			// without the item's properties the largest ispe is the image, the others are thumbnails or tiles
+			if width*height > config.Width*config.Height {
+				config.Width, config.Height = width, height
+			}
+		case "irot":
+			if len(property.data) > 0 {
+				rotation = int(property.data[0] & 0x3)
+			}
+		}
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return image.Config{}, errBadHEIF
+	}
+	// irot turns counterclockwise in steps of 90 degrees
+	if rotation%2 == 1 {
+		config.Width, config.Height = config.Height, config.Width
+	}
+	return config, nil
+}
+
+// primaryItem reads the item id from a pitm box
+func primaryItem(data []byte) (uint32, bool) {
+	if len(data) < 4 {
+		return 0, false
+	}
+	if data[0] == 0 {
+		if len(data) < 6 {
+			return 0, false
+		}
+		return uint32(binary.BigEndian.Uint16(data[4:])), true
+	}
+	if len(data) < 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data[4:]), true
+}
+
+// itemProperties reads the 1 based indexes into ipco of the properties of item from an ipma box
+func itemProperties(data []byte, item uint32) []int {
+	if len(data) < 8 {
+		return nil
+	}
+	var version, flags = data[0], data[3]
+	var count = binary.BigEndian.Uint32(data[4:])
+	var offset = 8
+
+	for i := uint32(0); i < count; i++ {
+		var id uint32
+		if version < 1 {
+			if offset+2 > len(data) {
+				return nil
+			}
+			id = uint32(binary.BigEndian.Uint16(data[offset:]))
+			offset += 2
+		} else {
+			if offset+4 > len(data) {
+				return nil
+			}
+			id = binary.BigEndian.Uint32(data[offset:])
+			offset += 4
+		}
+		if offset+1 > len(data) {
+			return nil
+		}
+		var associations = int(data[offset])
+		offset++
+
+		var indexes []int
+		for j := 0; j < associations; j++ {
+			// the top bit says whether the property is essential, the rest is the index
+			if flags&1 == 1 {
+				if offset+2 > len(data) {
+					return nil
+				}
+				indexes = append(indexes, int(binary.BigEndian.Uint16(data[offset:])&0x7FFF))
+				offset += 2
+			} else {
+				if offset+1 > len(data) {
+					return nil
+				}
+				indexes = append(indexes, int(data[offset]&0x7F))
+				offset++
+			}
+		}
+		if id == item {
+			return indexes
+		}
+	}
+	return nil
+}
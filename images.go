@@ -6,30 +6,49 @@ import (
 	"errors"
 	"fmt"
 	"image"
-	_ "image/jpeg"
-	_ "image/png"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
+	"slices"
 	"strings"
 
-	_ "golang.org/x/image/webp"
+	log "github.com/sirupsen/logrus"
 )
 
+const userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/101.0.4951.54 Safari/537.36"
+
 // ImageData represents all the information about an image in the app
 type ImageData struct {
 	URL       string
 	Bytes     []byte
 	Extension string
+	// Width and Height are the size the image is displayed at, they are swapped
+	// from the stored size when Orientation turns the image by 90 degrees
 	image.Config
-	Area      int
-	FileSize  int64
-	LocalPath string
+	// Orientation is the exif orientation, 1 when the image is displayed as it is stored
+	Orientation int
+	Area        int
+	FileSize    int64
+	LocalPath   string
+	// Provider is what found the image, "google" or the name of a site Resolver
+	Provider string
+	// SourcePage is the page the image url was found on: google's results page, or
+	// the page of a site Resolver that was followed to the image
+	SourcePage string
+	// NativeWidth and NativeHeight are the resolution the image really has detail for, they are
+	// smaller than Width and Height for upscaled copies. Set by EstimateNativeResolution, which the Get
+	// functions run on the image they return. The Find functions leave them 0 unless Thresholds.RejectUpscaled is set.
+	NativeWidth  int
+	NativeHeight int
+	// Score is set by SelectImage when it compares images by quality, images from the cache carry the score they got when they were found
+	Score *Score
 }
 
+// googleUploadURL is where images are uploaded to start a search
+var googleUploadURL = "https://lens.google.com/upload?re=df&st=1670027884133&ep=gisbubb"
+
 // uploadImage uploads the given image to google images
 // and returns the response as bytes.
 func uploadImage(filename string) ([]byte, error) {
@@ -60,7 +79,7 @@ func uploadImage(filename string) ([]byte, error) {
 	if err := writer.WriteField("filename", ""); err != nil {
 		return nil, fmt.Errorf("error adding form field filename; file: %s, error: %w", filename, err)
 	}
-	if err := writer.WriteField("hl", "en"); err != nil {
+	if err := writer.WriteField("hl", currentLocale().Language); err != nil {
 		return nil, fmt.Errorf("error adding form field hl; file: %s, error: %w", filename, err)
 	}
 
@@ -68,45 +87,151 @@ func uploadImage(filename string) ([]byte, error) {
 		return nil, fmt.Errorf("error closing html form writer; file: %s, error: %w", filename, err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, "https://lens.google.com/upload?re=df&st=1670027884133&ep=gisbubb", buf)
+	uploadURL, err := url.Parse(googleUploadURL)
 	if err != nil {
-		return nil, fmt.Errorf("error creating http request; file: %s, error: %w", filename, err)
+		return nil, fmt.Errorf("error parsing upload url; file: %s, error: %w", filename, err)
 	}
-	req.Header.Add("Content-Type", writer.FormDataContentType())
-	req.Header.Add("origin", "https://images.google.com/")
-	req.Header.Add("referer", "https://images.google.com/")
-	req.Header.Add("user-agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/101.0.4951.54 Safari/537.36")
+	uploadURL = currentLocale().localize(uploadURL)
 
-	var client = &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error sending http request; file: %s, error: %w", filename, err)
-	}
-	defer resp.Body.Close()
+	var client = &http.Client{Jar: jar}
+	for attempt := 0; attempt < 2; attempt++ {
+		limits.waitUpload()
+		req, err := http.NewRequest(http.MethodPost, uploadURL.String(), bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return nil, fmt.Errorf("error creating http request; file: %s, error: %w", filename, err)
+		}
+		req.Header.Add("Content-Type", writer.FormDataContentType())
+		req.Header.Add("origin", "https://images.google.com/")
+		req.Header.Add("referer", "https://images.google.com/")
+		req.Header.Add("user-agent", userAgent)
+		addLocaleHeaders(req.Header)
 
-	contents, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading resp.Body; file: %s, error: %w", filename, err)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error sending http request; file: %s, error: %w", filename, err)
+		}
+
+		contents, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading resp.Body; file: %s, error: %w", filename, err)
+		}
+
+		// the upload got redirected to the consent wall, accept it and send the upload again
+		if !isConsentPage(resp.Request.URL) {
+			return contents, nil
+		}
+		if err := acceptConsent(client, resp.Request.URL, contents); err != nil {
+			return nil, fmt.Errorf("error accepting consent; file: %s, error: %w", filename, err)
+		}
 	}
 
-	return contents, nil
+	return nil, fmt.Errorf("%w; file: %s", ErrConsent, filename)
 }
 
 func getURLFromUploadResponse(html []byte) (*url.URL, error) {
 
 	var redirectUrl = urlRegex.Find(html)
 	if len(redirectUrl) == 0 {
-		return nil, errors.New("did not find enough the url from upload response")
+		return nil, newParseError("upload_response", "did not find the url in the upload response")
 	}
 
-	return url.Parse(string(redirectUrl))
+	return parseAbsoluteURL("upload_response", string(redirectUrl))
 }
 
+// maxPageHops is how many pages getImage follows to get to an image
+const maxPageHops = 3
+
 // getImage downloads the given image and returns the ImageData
-// which includes the []byte.
-func getImage(url string) (*ImageData, error) {
-	var data = &ImageData{}
+// which includes the []byte. Urls on sites with a resolver are rewritten
+// to their original size first, and pages are searched for the image they show.
+func getImage(link string) (*ImageData, error) {
+	return followImage(link, nil)
+}
+
+// followImage is getImage, pages are the pages that were followed to get to link
+func followImage(link string, pages []string) (*ImageData, error) {
+	var u, err = url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing url: %s, error: %w", link, err)
+	}
+
+	body, contentType, err := download(link)
 
+	var r = resolverFor(u)
+	if r != nil && r.Rewrite != nil {
+		// a rewrite is only used when it's larger than the image it was rewritten from
+		var linked *ImageData
+		if err == nil && !strings.HasPrefix(contentType, "text/html") {
+			linked, _ = newImageData(link, body)
+		}
+		for _, candidate := range r.Rewrite(u) {
+			data, err := downloadImage(candidate.String())
+			if err != nil {
+				log.Tracef("[%s] %s rewrite %s failed: %s", link, r.Name, candidate, err)
+				continue
+			}
+			if linked != nil && data.Area <= linked.Area {
+				log.Tracef("[%s] %s rewrite %s is no larger: %dx%d", link, r.Name, candidate, data.Width, data.Height)
+				continue
+			}
+			return data, nil
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(contentType, "text/html") {
+		if r == nil || r.FromHTML == nil {
+			return nil, errors.New("resp was html: " + link)
+		}
+
+		var imageURL *url.URL
+		if r.Render {
+			imageURL, err = scrape(link, func(doc string) (*url.URL, error) { return r.FromHTML(u, doc) })
+		} else {
+			imageURL, err = r.FromHTML(u, string(body))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s image url, url: %s, error: %w", r.Name, link, err)
+		}
+		// the html is from another site, pages that link to each other must not be followed forever
+		pages = append(pages, link)
+		if slices.Contains(pages, imageURL.String()) {
+			return nil, fmt.Errorf("%s page links back to: %s, url: %s", r.Name, imageURL, link)
+		}
+		if len(pages) >= maxPageHops {
+			return nil, fmt.Errorf("%s page is more than %d pages from an image, url: %s", r.Name, maxPageHops, link)
+		}
+		data, err := followImage(imageURL.String(), pages)
+		if err != nil {
+			return nil, err
+		}
+		if data.SourcePage == "" {
+			data.SourcePage = link
+		}
+		return data, nil
+	}
+
+	return newImageData(link, body)
+}
+
+// downloadImage fetches url and fails if it is not an image.
+func downloadImage(url string) (*ImageData, error) {
+	var body, contentType, err = download(url)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(contentType, "text/html") {
+		return nil, errors.New("resp was html: " + url)
+	}
+	return newImageData(url, body)
+}
+
+// download fetches url and returns the body and its content type.
+func download(url string) ([]byte, string, error) {
 	var httpCient = &http.Client{
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{
@@ -117,35 +242,31 @@ func getImage(url string) (*ImageData, error) {
 	}
 	var req, err = http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error creating http req, url: %s, error: %w", url, err)
+		return nil, "", fmt.Errorf("error creating http req, url: %s, error: %w", url, err)
 	}
 	req.Header.Add("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:101.0) Gecko/20100101 Firefox/101.0")
 
 	resp, err := httpCient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error making http req, url: %s, error: %w", url, err)
+		return nil, "", fmt.Errorf("error making http req, url: %s, error: %w", url, err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("error reading resp.Body, url: %s, error: %w", url, err)
+		return nil, "", fmt.Errorf("error reading resp.Body, url: %s, error: %w", url, err)
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return nil, fmt.Errorf("non 2xx resp code: %d, url: %s", resp.StatusCode, url)
+		return nil, "", fmt.Errorf("non 2xx resp code: %d, url: %s", resp.StatusCode, url)
 	}
 
-	if strings.HasPrefix(resp.Header.Get("content-type"), "text/html") {
-		if regexp.MustCompile(`fbsbx|facebook`).MatchString(url) {
-			fbImageURL, err := scrape(url, findImageInFacebookHtml)
-			if err != nil {
-				return nil, fmt.Errorf("error getting facebook image url, url: %s, error: %w", url, err)
-			}
-			return getImage(fbImageURL.String())
-		}
-		return nil, errors.New("resp was html: " + url)
-	}
+	return body, resp.Header.Get("content-type"), nil
+}
+
+// newImageData decodes the image config of a downloaded image.
+func newImageData(url string, body []byte) (*ImageData, error) {
+	var data = &ImageData{}
 
 	var cp = bytes.NewReader(body)
 	imageDecode, ext, err := image.DecodeConfig(cp)
@@ -157,12 +278,18 @@ func getImage(url string) (*ImageData, error) {
 	data.Bytes = body
 	data.Extension = ext
 	data.Config = imageDecode
+	data.orient(body)
 	data.Area = data.Config.Height * data.Config.Width
 	data.FileSize = int64(len(body))
 
 	return data, nil
 }
 
+// GetImageConfigFromURL downloads the image at link and returns its ImageData
+func GetImageConfigFromURL(link string) (*ImageData, error) {
+	return downloadImage(link)
+}
+
 // GetImageConfigFromFile returns ImageData for the given image
 func GetImageConfigFromFile(filename string) (*ImageData, error) {
 	var data = new(ImageData)
@@ -174,19 +301,20 @@ func GetImageConfigFromFile(filename string) (*ImageData, error) {
 	}
 	defer file.Close()
 
-	config, ext, err := image.DecodeConfig(file)
+	imageBody, err := io.ReadAll(file)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding image: %s, error: %w", filename, err)
+		return nil, fmt.Errorf("error reading image contents: %s, error: %w", filename, err)
 	}
 
-	imageBody, err := io.ReadAll(file)
+	config, ext, err := image.DecodeConfig(bytes.NewReader(imageBody))
 	if err != nil {
-		return nil, fmt.Errorf("error reading image contents: %s, error: %w", filename, err)
+		return nil, fmt.Errorf("error decoding image: %s, error: %w", filename, err)
 	}
 
 	data.Config = config
 	data.Bytes = imageBody
 	data.Extension = ext
+	data.orient(imageBody)
 	data.Area = config.Width * config.Height
 	if stat, err := file.Stat(); err != nil {
 		return nil, fmt.Errorf("error stat'ing file: %s, error: %w", filename, err)
@@ -196,3 +324,12 @@ func GetImageConfigFromFile(filename string) (*ImageData, error) {
 
 	return data, nil
 }
+
+// orient reads the exif orientation from the image contents and swaps
+// Width and Height when the image is displayed turned by 90 degrees
+func (d *ImageData) orient(contents []byte) {
+	d.Orientation = orientationOf(contents)
+	if swapsDimensions(d.Orientation) {
+		d.Width, d.Height = d.Height, d.Width
+	}
+}
@@ -0,0 +1,27 @@
+package imageupsizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetURLFromUploadResponse(t *testing.T) {
+	t.Parallel()
+
+	var link, err = getURLFromUploadResponse([]byte(readFixture(t, "upload_response.html")))
+	assert.NoError(t, err)
+	assert.Equal(t, "lens.google.com", link.Host)
+	assert.Equal(t, "/search", link.Path)
+
+	_, err = getURLFromUploadResponse([]byte("<html></html>"))
+	assert.ErrorIs(t, err, ErrParse)
+}
+
+func FuzzGetURLFromUploadResponse(f *testing.F) {
+	addFixtures(f)
+	f.Fuzz(func(t *testing.T, doc string) {
+		var link, err = getURLFromUploadResponse([]byte(doc))
+		checkParserResult(t, link, err)
+	})
+}
@@ -0,0 +1,136 @@
+package imageupsizer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReplaceWithBackup replaces original with upsized and keeps original at backup. When upsized was
+// converted to another format the replacement gets its extension, e.g. photo.png becomes photo.jpg.
+// The backup is complete before original is touched and original is replaced with a rename, so
+// at any point either the original or the upsized image is in place. It returns the name of the replacement.
+func ReplaceWithBackup(original, upsized, backup string) (string, error) {
+	var info, err = os.Stat(original)
+	if err != nil {
+		return "", fmt.Errorf("error stat'ing file: %s, error: %w", original, err)
+	}
+	// the replacement gets the permissions of the original, not those of the temp file it was written to
+	if err := os.Chmod(upsized, info.Mode().Perm()); err != nil {
+		return "", fmt.Errorf("error setting permissions: %s, error: %w", upsized, err)
+	}
+
+	var target = original
+	if formatOfExtension(filepath.Ext(original)) != formatOfExtension(filepath.Ext(upsized)) {
+		target = strings.TrimSuffix(original, filepath.Ext(original)) + filepath.Ext(upsized)
+	}
+	if target != original {
+		// don't replace another file that has the new name
+		var placeholder, err = os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			return "", fmt.Errorf("%w: %s", ErrOutputExists, target)
+		} else if err != nil {
+			return "", fmt.Errorf("error creating file: %s, error: %w", target, err)
+		}
+		placeholder.Close()
+	}
+
+	if err := backupFile(original, backup); err != nil {
+		if target != original {
+			os.Remove(target)
+		}
+		return "", err
+	}
+
+	if err := os.Rename(upsized, target); err != nil {
+		if target != original {
+			os.Remove(target)
+		}
+		return "", fmt.Errorf("error renaming: %s to: %s, error: %w", upsized, target, err)
+	}
+	if target != original {
+		if err := os.Remove(original); err != nil {
+			return target, fmt.Errorf("error removing original: %s, error: %w", original, err)
+		}
+	}
+	return target, syncDir(filepath.Dir(target))
+}
+
+// backupFile makes backup a copy of original that is kept when original is replaced
+func backupFile(original, backup string) error {
+	if err := os.MkdirAll(filepath.Dir(backup), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating dir: %s, error: %w", filepath.Dir(backup), err)
+	}
+	// a hard link keeps the original's contents when it's renamed over, other file systems need a copy
+	if err := os.Link(original, backup); err != nil {
+		if err := copyFile(original, backup); err != nil {
+			return err
+		}
+	}
+	return syncDir(filepath.Dir(backup))
+}
+
+// RestoreBackup undoes ReplaceWithBackup: backup is moved back to original and replacement is removed.
+func RestoreBackup(original, replacement, backup string) error {
+	var info, err = os.Stat(backup)
+	if err != nil {
+		return fmt.Errorf("error reading backup: %s, error: %w", backup, err)
+	}
+
+	if err := os.Rename(backup, original); err != nil {
+		// the backup is on another file system
+		var copyErr = writeAtomic(original, func(file *os.File) error {
+			if err := file.Chmod(info.Mode().Perm()); err != nil {
+				return fmt.Errorf("error setting permissions: %s, error: %w", file.Name(), err)
+			}
+			return copyContents(backup, file)
+		}, nil)
+		if copyErr != nil {
+			return copyErr
+		}
+		if err := os.Remove(backup); err != nil {
+			return fmt.Errorf("error removing backup: %s, error: %w", backup, err)
+		}
+	}
+
+	if replacement != "" && replacement != original {
+		if err := os.Remove(replacement); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing replacement: %s, error: %w", replacement, err)
+		}
+	}
+	return syncDir(filepath.Dir(original))
+}
+
+// copyFile copies from to a new file to, it fails if to exists
+func copyFile(from, to string) error {
+	var out, err = os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("error creating file: %s, error: %w", to, err)
+	}
+	if err := copyContents(from, out); err != nil {
+		out.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("error syncing file: %s, error: %w", to, err)
+	}
+	return out.Close()
+}
+
+// copyContents writes the contents of from to out
+func copyContents(from string, out *os.File) error {
+	var in, err = os.Open(from)
+	if err != nil {
+		return fmt.Errorf("error opening file: %s, error: %w", from, err)
+	}
+	defer in.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("error copying: %s to: %s, error: %w", from, out.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package imageupsizer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceWithBackup(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var write = func(name, contents string, perm os.FileMode) string {
+		var filename = filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0700))
+		assert.NoError(t, os.WriteFile(filename, []byte(contents), perm))
+		return filename
+	}
+	var backups = filepath.Join(dir, ".backup", "run")
+
+	// same format, the original is replaced under its own name
+	var original = write("album/photo.jpeg", "original", 0644)
+	var replacement, err = ReplaceWithBackup(original, write("staging/a.jpg", "upsized", 0600), filepath.Join(backups, "album/photo.jpeg"))
+	assert.NoError(t, err)
+	assert.Equal(t, original, replacement)
+	assert.Equal(t, "upsized", string(readFile(t, original)))
+	assert.Equal(t, "original", string(readFile(t, filepath.Join(backups, "album/photo.jpeg"))))
+	info, err := os.Stat(original)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	assert.NoError(t, RestoreBackup(original, replacement, filepath.Join(backups, "album/photo.jpeg")))
+	assert.Equal(t, "original", string(readFile(t, original)))
+	assert.NoFileExists(t, filepath.Join(backups, "album/photo.jpeg"))
+
+	// converted, the replacement gets the new extension and the original goes
+	original = write("album/icon.png", "original", 0644)
+	replacement, err = ReplaceWithBackup(original, write("staging/b.jpg", "upsized", 0600), filepath.Join(backups, "album/icon.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "album/icon.jpg"), replacement)
+	assert.NoFileExists(t, original)
+	assert.Equal(t, "upsized", string(readFile(t, replacement)))
+
+	assert.NoError(t, RestoreBackup(original, replacement, filepath.Join(backups, "album/icon.png")))
+	assert.Equal(t, "original", string(readFile(t, original)))
+	assert.NoFileExists(t, replacement)
+
+	// another file already has the new name, nothing is touched
+	original = write("album/logo.png", "original", 0644)
+	write("album/logo.jpg", "other", 0644)
+	var upsized = write("staging/c.jpg", "upsized", 0600)
+	_, err = ReplaceWithBackup(original, upsized, filepath.Join(backups, "album/logo.png"))
+	assert.ErrorIs(t, err, ErrOutputExists)
+	assert.Equal(t, "original", string(readFile(t, original)))
+	assert.Equal(t, "other", string(readFile(t, filepath.Join(dir, "album/logo.jpg"))))
+	assert.FileExists(t, upsized)
+	assert.NoFileExists(t, filepath.Join(backups, "album/logo.png"))
+
+	// a backup from before is never overwritten
+	original = write("album/old.jpg", "original", 0644)
+	write(".backup/run/album/old.jpg", "older backup", 0644)
+	_, err = ReplaceWithBackup(original, write("staging/d.jpg", "upsized", 0600), filepath.Join(backups, "album/old.jpg"))
+	assert.Error(t, err)
+	assert.Equal(t, "original", string(readFile(t, original)))
+
+	assert.ErrorIs(t, RestoreBackup(original, original, filepath.Join(backups, "missing.jpg")), os.ErrNotExist)
+}
@@ -0,0 +1,73 @@
+package imageupsizer
+
+import (
+	"sync"
+	"time"
+)
+
+// Limits bound the load put on google however many files are upsized at the same time.
+// Zero values turn a limit off.
+type Limits struct {
+	// Browsers is how many chrome instances may be scraping at the same time
+	Browsers int
+	// UploadInterval is the least time between two image uploads
+	UploadInterval time.Duration
+}
+
+// limiter enforces Limits, it's shared by every search
+type limiter struct {
+	browsers chan struct{}
+
+	mu       sync.Mutex
+	interval time.Duration
+	// next is the earliest time the next upload may start
+	next time.Time
+}
+
+func newLimiter(l Limits) *limiter {
+	var lim = &limiter{interval: l.UploadInterval}
+	if l.Browsers > 0 {
+		lim.browsers = make(chan struct{}, l.Browsers)
+	}
+	return lim
+}
+
+// limits are used by scrape and uploadImage
+var limits = newLimiter(Limits{})
+
+// SetLimits sets how hard google may be hit.
+// It is not safe to call while searches are in flight.
+func SetLimits(l Limits) {
+	limits = newLimiter(l)
+}
+
+// acquireBrowser waits until another chrome instance may be started, release it with releaseBrowser.
+func (l *limiter) acquireBrowser() {
+	if l.browsers != nil {
+		l.browsers <- struct{}{}
+	}
+}
+
+func (l *limiter) releaseBrowser() {
+	if l.browsers != nil {
+		<-l.browsers
+	}
+}
+
+// waitUpload waits until the next upload may start. Each caller reserves its own slot
+// so uploads waiting at the same time are spread out by the interval.
+func (l *limiter) waitUpload() {
+	if l.interval <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	var start = l.next
+	if now := time.Now(); start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(start))
+}
@@ -0,0 +1,66 @@
+package imageupsizer
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterBrowsers(t *testing.T) {
+	t.Parallel()
+
+	var l = newLimiter(Limits{Browsers: 2})
+	var running, most atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.acquireBrowser()
+			defer l.releaseBrowser()
+
+			var now = running.Add(1)
+			for {
+				var m = most.Load()
+				if now <= m || most.CompareAndSwap(m, now) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), most.Load())
+
+	// no limit never blocks
+	l = newLimiter(Limits{})
+	for i := 0; i < 8; i++ {
+		l.acquireBrowser()
+	}
+}
+
+func TestLimiterUploads(t *testing.T) {
+	t.Parallel()
+
+	var l = newLimiter(Limits{UploadInterval: 20 * time.Millisecond})
+	var start = time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.waitUpload()
+		}()
+	}
+	wg.Wait()
+	// the first starts right away, the other three wait their turn
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+
+	start = time.Now()
+	newLimiter(Limits{}).waitUpload()
+	assert.Less(t, time.Since(start), 10*time.Millisecond)
+}
@@ -0,0 +1,184 @@
+package imageupsizer
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Locale is the language and region google is asked to answer in.
+type Locale struct {
+	// Language is the interface language sent as hl, e.g. "en", "de", "pt-BR"
+	Language string
+	// Region is the two letter country sent as gl, e.g. "us", "de". Empty lets google decide.
+	Region string
+}
+
+var (
+	localeMu sync.RWMutex
+	locale   = Locale{Language: "en"}
+)
+
+// SetLocale changes the language and region used for every search.
+// Searches already in flight may finish in the old locale.
+func SetLocale(l Locale) {
+	if l.Language == "" {
+		l.Language = "en"
+	}
+	localeMu.Lock()
+	defer localeMu.Unlock()
+	locale = l
+}
+
+// currentLocale is the locale set by SetLocale.
+func currentLocale() Locale {
+	localeMu.RLock()
+	defer localeMu.RUnlock()
+	return locale
+}
+
+// acceptLanguage is the Accept-Language header matching the configured locale.
+func (l Locale) acceptLanguage() string {
+	var lang = l.Language
+	if l.Region != "" && !strings.Contains(lang, "-") {
+		lang += "-" + strings.ToUpper(l.Region)
+	}
+	if strings.HasPrefix(lang, "en") {
+		return lang + ",en;q=0.9"
+	}
+	return lang + "," + strings.SplitN(l.Language, "-", 2)[0] + ";q=0.9,en;q=0.8"
+}
+
+// localize adds hl and gl to google urls so every page in the flow is served in the same locale.
+func (l Locale) localize(u *url.URL) *url.URL {
+	if !isGoogleHost(u) {
+		return u
+	}
+	var localized = *u
+	var query = localized.Query()
+	query.Set("hl", l.Language)
+	if l.Region != "" {
+		query.Set("gl", l.Region)
+	}
+	localized.RawQuery = query.Encode()
+	return &localized
+}
+
+// isGoogleHost reports whether u is on google.com, a country domain like google.de or google.co.uk, or a subdomain of one.
+func isGoogleHost(u *url.URL) bool {
+	var site, err = publicsuffix.EffectiveTLDPlusOne(strings.ToLower(u.Hostname()))
+	return err == nil && googleDomains[site]
+}
+
+// googleDomains are the sites google search is served from, google.com and the country domains
+var googleDomains = map[string]bool{
+	"google.com": true, "google.ad": true, "google.ae": true, "google.com.af": true, "google.com.ag": true, "google.al": true,
+	"google.am": true, "google.co.ao": true, "google.com.ar": true, "google.as": true, "google.at": true, "google.com.au": true,
+	"google.az": true, "google.ba": true, "google.com.bd": true, "google.be": true, "google.bf": true, "google.bg": true,
+	"google.com.bh": true, "google.bi": true, "google.bj": true, "google.com.bn": true, "google.com.bo": true, "google.com.br": true,
+	"google.bs": true, "google.bt": true, "google.co.bw": true, "google.by": true, "google.com.bz": true, "google.ca": true,
+	"google.cat": true, "google.cd": true, "google.cf": true, "google.cg": true, "google.ch": true, "google.ci": true,
+	"google.co.ck": true, "google.cl": true, "google.cm": true, "google.cn": true, "google.com.co": true, "google.co.cr": true,
+	"google.com.cu": true, "google.cv": true, "google.com.cy": true, "google.cz": true, "google.de": true, "google.dj": true,
+	"google.dk": true, "google.dm": true, "google.com.do": true, "google.dz": true, "google.com.ec": true, "google.ee": true,
+	"google.com.eg": true, "google.es": true, "google.com.et": true, "google.fi": true, "google.com.fj": true, "google.fm": true,
+	"google.fr": true, "google.ga": true, "google.ge": true, "google.gg": true, "google.com.gh": true, "google.com.gi": true,
+	"google.gl": true, "google.gm": true, "google.gr": true, "google.com.gt": true, "google.gy": true, "google.com.hk": true,
+	"google.hn": true, "google.hr": true, "google.ht": true, "google.hu": true, "google.co.id": true, "google.ie": true,
+	"google.co.il": true, "google.im": true, "google.co.in": true, "google.iq": true, "google.is": true, "google.it": true,
+	"google.je": true, "google.com.jm": true, "google.jo": true, "google.co.jp": true, "google.co.ke": true, "google.com.kh": true,
+	"google.ki": true, "google.kg": true, "google.co.kr": true, "google.com.kw": true, "google.kz": true, "google.la": true,
+	"google.com.lb": true, "google.li": true, "google.lk": true, "google.co.ls": true, "google.lt": true, "google.lu": true,
+	"google.lv": true, "google.com.ly": true, "google.co.ma": true, "google.md": true, "google.me": true, "google.mg": true,
+	"google.mk": true, "google.ml": true, "google.com.mm": true, "google.mn": true, "google.com.mt": true, "google.mu": true,
+	"google.mv": true, "google.mw": true, "google.com.mx": true, "google.com.my": true, "google.co.mz": true, "google.com.na": true,
+	"google.com.ng": true, "google.com.ni": true, "google.ne": true, "google.nl": true, "google.no": true, "google.com.np": true,
+	"google.nr": true, "google.nu": true, "google.co.nz": true, "google.com.om": true, "google.com.pa": true, "google.com.pe": true,
+	"google.com.pg": true, "google.com.ph": true, "google.com.pk": true, "google.pl": true, "google.pn": true, "google.com.pr": true,
+	"google.ps": true, "google.pt": true, "google.com.py": true, "google.com.qa": true, "google.ro": true, "google.rs": true,
+	"google.ru": true, "google.rw": true, "google.com.sa": true, "google.com.sb": true, "google.sc": true, "google.se": true,
+	"google.com.sg": true, "google.sh": true, "google.si": true, "google.sk": true, "google.com.sl": true, "google.sn": true,
+	"google.so": true, "google.sm": true, "google.sr": true, "google.st": true, "google.com.sv": true, "google.td": true,
+	"google.tg": true, "google.co.th": true, "google.com.tj": true, "google.tl": true, "google.tm": true, "google.tn": true,
+	"google.to": true, "google.com.tr": true, "google.tt": true, "google.com.tw": true, "google.co.tz": true, "google.com.ua": true,
+	"google.co.ug": true, "google.co.uk": true, "google.com.uy": true, "google.co.uz": true, "google.com.vc": true, "google.co.ve": true,
+	"google.co.vi": true, "google.com.vn": true, "google.vu": true, "google.ws": true, "google.co.za": true, "google.co.zm": true,
+	"google.co.zw": true,
+}
+
+// messages google shows instead of results, per language.
+// Every table is checked regardless of the configured locale because
+// google does not always honour hl and falls back to the region's language.
+var (
+	messagesMu sync.RWMutex
+
+	otherSizesMessages = map[string][]string{
+		"en": {"No other sizes of this image found."},
+		"de": {"Keine anderen Größen dieses Bildes gefunden."},
+		"fr": {"Aucune autre taille de cette image n'a été trouvée."},
+		"es": {"No se han encontrado otros tamaños de esta imagen."},
+		"it": {"Nessun'altra dimensione trovata per questa immagine."},
+		"pt": {"Nenhum outro tamanho desta imagem foi encontrado."},
+		"nl": {"Geen andere formaten van deze afbeelding gevonden."},
+		"pl": {"Nie znaleziono innych rozmiarów tego obrazu."},
+		"ja": {"この画像の他のサイズは見つかりませんでした。"},
+	}
+
+	noMatchesMessages = map[string][]string{
+		"en": {"Looks like there aren’t any matches for your search", "Looks like there aren't any matches for your search"},
+		"de": {"Es wurden keine Übereinstimmungen für deine Suche gefunden"},
+		"fr": {"Il semble qu'aucun résultat ne corresponde à votre recherche"},
+		"es": {"Parece que no hay coincidencias para tu búsqueda"},
+		"it": {"Sembra che non ci siano corrispondenze per la tua ricerca"},
+		"pt": {"Parece que não há correspondências para sua pesquisa"},
+		"nl": {"Er zijn geen overeenkomsten gevonden voor je zoekopdracht"},
+		"pl": {"Wygląda na to, że nie ma żadnych wyników pasujących do Twojego wyszukiwania"},
+		"ja": {"一致する検索結果はありませんでした"},
+	}
+)
+
+// RegisterNoResultsMessages adds the text google shows for "no other sizes" and
+// "no matches" in the given language, for languages that are not built in or when google changes its wording.
+// Either message may be empty.
+func RegisterNoResultsMessages(language, otherSizes, noMatches string) {
+	messagesMu.Lock()
+	defer messagesMu.Unlock()
+
+	if otherSizes != "" {
+		otherSizesMessages[language] = append(otherSizesMessages[language], otherSizes)
+	}
+	if noMatches != "" {
+		noMatchesMessages[language] = append(noMatchesMessages[language], noMatches)
+	}
+}
+
+// containsMessage reports whether html contains any message in any language of the table.
+func containsMessage(html string, table map[string][]string) bool {
+	messagesMu.RLock()
+	defer messagesMu.RUnlock()
+
+	var normalized = normalizeMessage(html)
+	for _, messages := range table {
+		for _, message := range messages {
+			if strings.Contains(normalized, normalizeMessage(message)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var messageReplacer = strings.NewReplacer("’", "'", "&#39;", "'", "&#x27;", "'", "&apos;", "'", "\u00a0", " ", "&nbsp;", " ")
+
+// normalizeMessage folds the typographic differences google varies between pages.
+func normalizeMessage(s string) string {
+	return messageReplacer.Replace(s)
+}
+
+// addLocaleHeaders sets the Accept-Language header for the configured locale.
+func addLocaleHeaders(header http.Header) {
+	header.Set("Accept-Language", currentLocale().acceptLanguage())
+}
@@ -0,0 +1,113 @@
+package imageupsizer
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptLanguage(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		locale Locale
+		want   string
+	}{
+		{Locale{Language: "en"}, "en,en;q=0.9"},
+		{Locale{Language: "en", Region: "gb"}, "en-GB,en;q=0.9"},
+		{Locale{Language: "de"}, "de,de;q=0.9,en;q=0.8"},
+		{Locale{Language: "de", Region: "at"}, "de-AT,de;q=0.9,en;q=0.8"},
+		{Locale{Language: "pt-BR", Region: "pt"}, "pt-BR,pt;q=0.9,en;q=0.8"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, test.locale.acceptLanguage(), test.locale)
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	t.Parallel()
+
+	var l = Locale{Language: "de", Region: "at"}
+	for _, link := range []string{
+		"https://www.google.com/search?q=sunflower",
+		"https://google.com/search?q=sunflower",
+		"https://www.google.de/search?q=sunflower",
+		"https://www.google.co.uk/search?q=sunflower",
+		"https://lens.google.com/upload?q=sunflower",
+		"https://WWW.GOOGLE.COM/search?q=sunflower",
+	} {
+		var u, err = url.Parse(link)
+		require.NoError(t, err)
+		var localized = l.localize(u)
+		assert.Equal(t, "de", localized.Query().Get("hl"), link)
+		assert.Equal(t, "at", localized.Query().Get("gl"), link)
+		assert.Equal(t, "sunflower", localized.Query().Get("q"), link)
+		assert.Empty(t, u.Query().Get("hl"), "the original is not changed")
+	}
+
+	for _, link := range []string{
+		"https://evilgoogle.com/search?q=sunflower",
+		"https://google.com.evil.com/search?q=sunflower",
+		"https://google.evil.com/search?q=sunflower",
+		"https://example.com/google.com",
+		"https://google.blogspot.com/search?q=sunflower",
+		"https://google.github.io/search?q=sunflower",
+		"https://google.appspot.com/search?q=sunflower",
+	} {
+		var u, err = url.Parse(link)
+		require.NoError(t, err)
+		assert.Equal(t, link, l.localize(u).String(), link)
+	}
+
+	// no region leaves gl to google
+	var u, err = url.Parse("https://www.google.com/search?q=sunflower&gl=us")
+	require.NoError(t, err)
+	assert.Equal(t, "us", Locale{Language: "fr"}.localize(u).Query().Get("gl"))
+}
+
+func TestContainsMessage(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, containsMessage("<p>Looks like there aren’t any matches for your search</p>", noMatchesMessages))
+	assert.True(t, containsMessage("<p>Looks like there aren&#39;t any matches for your search</p>", noMatchesMessages))
+	assert.True(t, containsMessage("<p>No other sizes of this image found.</p>", otherSizesMessages))
+	assert.False(t, containsMessage("<p>No other sizes of this image found.</p>", noMatchesMessages))
+	assert.False(t, containsMessage("<p>sunflower</p>", otherSizesMessages))
+
+	// every built in language has a page behind it
+	for language := range noMatchesMessages {
+		if language == "en" {
+			continue
+		}
+		var page = readFixture(t, fmt.Sprintf("no_matches_%s.html", language))
+		assert.True(t, containsMessage(page, noMatchesMessages), language)
+		assert.False(t, containsMessage(page, otherSizesMessages), language)
+		var _, err = findImageResultsInHtml(page)
+		assert.ErrorIs(t, err, NoMatchesError, language)
+	}
+	for language := range otherSizesMessages {
+		if language == "en" {
+			continue
+		}
+		var page = readFixture(t, fmt.Sprintf("other_sizes_%s.html", language))
+		assert.True(t, containsMessage(page, otherSizesMessages), language)
+		var _, err = findAllSizesLinkInHtml(page)
+		assert.ErrorIs(t, err, OtherSizesNotAvailableError, language)
+	}
+}
+
+func TestResultIDs(t *testing.T) {
+	t.Parallel()
+
+	// the grid is found by its data-ri whatever the language of the heading
+	var root, err = parseHTML("largest_image", `<div data-id="header"></div><h1>Bildergebnisse</h1><div data-ri="0" data-id="a"></div><div data-ri="1" data-id="b"></div>`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, resultIDs(root))
+
+	root, err = parseHTML("largest_image", `<div data-id="a"></div><div data-id="b"></div>`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, resultIDs(root))
+}
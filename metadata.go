@@ -0,0 +1,483 @@
+package imageupsizer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// MetadataPolicy is what CopyMetadata copies from the original onto the upsized image.
+type MetadataPolicy string
+
+const (
+	// MetadataAll copies the exif, icc profile and xmp of the original as they are
+	MetadataAll MetadataPolicy = "all"
+	// MetadataSafe copies the exif date, camera and gps tags, the icc profile and the xmp keywords and rating
+	MetadataSafe MetadataPolicy = "safe"
+	// MetadataStrip removes all metadata from the upsized image
+	MetadataStrip MetadataPolicy = "strip"
+)
+
+// ErrUnsupportedMetadata is returned when metadata can't be written to a file of that format
+var ErrUnsupportedMetadata = errors.New("writing metadata is only supported for jpeg and png")
+
+// Metadata is the metadata of an image that is worth carrying over to a larger copy.
+// EXIF is the tiff structure without the "Exif\x00\x00" prefix jpegs have.
+type Metadata struct {
+	EXIF []byte
+	ICC  []byte
+	XMP  []byte
+}
+
+// CopyMetadata replaces the metadata of to with the metadata of from allowed by policy.
+// The exif orientation of to is kept whatever the policy.
+func CopyMetadata(from, to string, policy MetadataPolicy) error {
+	var md = new(Metadata)
+	if policy != MetadataStrip {
+		var err error
+		md, err = ReadMetadata(from)
+		if err != nil {
+			return err
+		}
+		if policy == MetadataSafe {
+			md = md.Safe()
+		}
+	}
+
+	// the pixels of to are stored the way its own orientation says, copying the orientation
+	// of from would turn it a second time
+	target, err := ReadMetadata(to)
+	if err != nil {
+		return err
+	}
+	exif, err := withOrientation(md.EXIF, exifOrientation(target.EXIF))
+	if err != nil {
+		return fmt.Errorf("error setting orientation: %s, error: %w", to, err)
+	}
+	md = &Metadata{EXIF: exif, ICC: md.ICC, XMP: md.XMP}
+
+	return WriteMetadata(to, md)
+}
+
+// Safe returns the safe subset of the metadata, see MetadataSafe. Exif that can't be parsed is dropped.
+func (m *Metadata) Safe() *Metadata {
+	var safe = &Metadata{ICC: m.ICC}
+	if len(m.EXIF) > 0 {
+		var exif, err = safeEXIF(m.EXIF)
+		if err == nil {
+			safe.EXIF = exif
+		}
+	}
+	if len(m.XMP) > 0 {
+		safe.XMP = safeXMP(m.XMP)
+	}
+	return safe
+}
+
+// ReadMetadata reads the metadata of a jpeg, png or webp file.
+func ReadMetadata(filename string) (*Metadata, error) {
+	var data, err = os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %s, error: %w", filename, err)
+	}
+
+	md, err := parseMetadata(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata: %s, error: %w", filename, err)
+	}
+	return md, nil
+}
+
+// parseMetadata finds the metadata in the contents of a jpeg, png or webp file
+func parseMetadata(data []byte) (*Metadata, error) {
+	switch {
+	case bytes.HasPrefix(data, jpegSOI):
+		return readJPEGMetadata(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return readPNGMetadata(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return readWebPMetadata(data)
+	default:
+		// other formats have nothing we can copy
+		return new(Metadata), nil
+	}
+}
+
+// WriteMetadata replaces the exif, icc profile and xmp of a jpeg or png file with md, the file is replaced atomically.
+func WriteMetadata(filename string, md *Metadata) error {
+	var data, err = os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("error reading file: %s, error: %w", filename, err)
+	}
+
+	var updated []byte
+	switch {
+	case bytes.HasPrefix(data, jpegSOI):
+		updated, err = writeJPEGMetadata(data, md)
+	case bytes.HasPrefix(data, pngSignature):
+		updated, err = writePNGMetadata(data, md)
+	default:
+		return fmt.Errorf("%s: %w", filename, ErrUnsupportedMetadata)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing metadata: %s, error: %w", filename, err)
+	}
+
+	return writeAtomic(filename, func(file *os.File) error {
+		_, err := file.Write(updated)
+		return err
+	}, verifyImage)
+}
+
+var (
+	jpegSOI       = []byte{0xFF, 0xD8}
+	exifPrefix    = []byte("Exif\x00\x00")
+	xmpPrefix     = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccPrefix     = []byte("ICC_PROFILE\x00")
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+	pngXMPKeyword = "XML:com.adobe.xmp"
+)
+
+const (
+	jpegAPP0 = 0xE0
+	jpegAPP1 = 0xE1
+	jpegAPP2 = 0xE2
+	jpegSOS  = 0xDA
+	// jpegMaxSegment is the most a segment can hold after its length
+	jpegMaxSegment = 0xFFFF - 2
+)
+
+// jpegSegment is a marker segment before the image data
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// splitJPEG splits a jpeg into the segments before the scan and the rest, starting with the SOS marker
+func splitJPEG(data []byte) ([]jpegSegment, []byte, error) {
+	var segments []jpegSegment
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, nil, errors.New("jpeg ends before the image data")
+		}
+		var marker = data[i+1]
+		// markers can be padded with any number of 0xFF
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		if marker == jpegSOS {
+			return segments, data[i:], nil
+		}
+		var length = int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, nil, errors.New("jpeg segment is too long")
+		}
+		segments = append(segments, jpegSegment{marker: marker, data: data[i+4 : i+2+length]})
+		i += 2 + length
+	}
+}
+
+func readJPEGMetadata(data []byte) (*Metadata, error) {
+	var segments, _, err = splitJPEG(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var md = new(Metadata)
+	// the icc profile is split over numbered APP2 segments
+	var iccChunks = make(map[byte][]byte)
+	for _, segment := range segments {
+		switch {
+		case segment.marker == jpegAPP1 && bytes.HasPrefix(segment.data, exifPrefix):
+			md.EXIF = segment.data[len(exifPrefix):]
+		case segment.marker == jpegAPP1 && bytes.HasPrefix(segment.data, xmpPrefix):
+			md.XMP = segment.data[len(xmpPrefix):]
+		case segment.marker == jpegAPP2 && bytes.HasPrefix(segment.data, iccPrefix) && len(segment.data) > len(iccPrefix)+2:
+			iccChunks[segment.data[len(iccPrefix)]] = segment.data[len(iccPrefix)+2:]
+		}
+	}
+	for seq := 1; seq <= len(iccChunks); seq++ {
+		md.ICC = append(md.ICC, iccChunks[byte(seq)]...)
+	}
+	return md, nil
+}
+
+// isJPEGMetadata reports whether the segment is one WriteMetadata replaces
+func isJPEGMetadata(segment jpegSegment) bool {
+	return (segment.marker == jpegAPP1 && (bytes.HasPrefix(segment.data, exifPrefix) || bytes.HasPrefix(segment.data, xmpPrefix))) ||
+		(segment.marker == jpegAPP2 && bytes.HasPrefix(segment.data, iccPrefix))
+}
+
+func writeJPEGMetadata(data []byte, md *Metadata) ([]byte, error) {
+	var segments, scan, err = splitJPEG(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []jpegSegment
+	if len(md.EXIF) > 0 {
+		if len(exifPrefix)+len(md.EXIF) > jpegMaxSegment {
+			return nil, errors.New("exif is too large for a jpeg segment")
+		}
+		added = append(added, jpegSegment{marker: jpegAPP1, data: append(append([]byte{}, exifPrefix...), md.EXIF...)})
+	}
+	if len(md.XMP) > 0 {
+		// larger xmp needs the extended xmp scheme, it's rare enough to not bother
+		if len(xmpPrefix)+len(md.XMP) > jpegMaxSegment {
+			return nil, errors.New("xmp is too large for a jpeg segment")
+		}
+		added = append(added, jpegSegment{marker: jpegAPP1, data: append(append([]byte{}, xmpPrefix...), md.XMP...)})
+	}
+	if len(md.ICC) > 0 {
+		var chunkSize = jpegMaxSegment - len(iccPrefix) - 2
+		var count = (len(md.ICC) + chunkSize - 1) / chunkSize
+		if count > 255 {
+			return nil, errors.New("icc profile is too large for a jpeg")
+		}
+		for i := 0; i < count; i++ {
+			var chunk = md.ICC[i*chunkSize : min(len(md.ICC), (i+1)*chunkSize)]
+			var segment = append(append([]byte{}, iccPrefix...), byte(i+1), byte(count))
+			added = append(added, jpegSegment{marker: jpegAPP2, data: append(segment, chunk...)})
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(jpegSOI)
+	var write = func(segment jpegSegment) {
+		out.Write([]byte{0xFF, segment.marker})
+		_ = binary.Write(&out, binary.BigEndian, uint16(len(segment.data)+2))
+		out.Write(segment.data)
+	}
+
+	// the new segments go after the JFIF header, if there is one, and before everything else
+	var i int
+	for ; i < len(segments) && segments[i].marker == jpegAPP0; i++ {
+		write(segments[i])
+	}
+	for _, segment := range added {
+		write(segment)
+	}
+	for _, segment := range segments[i:] {
+		if !isJPEGMetadata(segment) {
+			write(segment)
+		}
+	}
+	out.Write(scan)
+	return out.Bytes(), nil
+}
+
+// pngChunk is one chunk of a png file
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+func splitPNG(data []byte) ([]pngChunk, error) {
+	var chunks []pngChunk
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, errors.New("png chunk is truncated")
+		}
+		var length = int(binary.BigEndian.Uint32(data[i:]))
+		if length < 0 || i+12+length > len(data) {
+			return nil, errors.New("png chunk is too long")
+		}
+		chunks = append(chunks, pngChunk{typ: string(data[i+4 : i+8]), data: data[i+8 : i+8+length]})
+		i += 12 + length
+	}
+	return chunks, nil
+}
+
+func readPNGMetadata(data []byte) (*Metadata, error) {
+	var chunks, err = splitPNG(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var md = new(Metadata)
+	for _, chunk := range chunks {
+		switch chunk.typ {
+		case "eXIf":
+			md.EXIF = chunk.data
+		case "iCCP":
+			// profile name, a null, the compression method, then the zlib compressed profile
+			var idx = bytes.IndexByte(chunk.data, 0)
+			if idx == -1 || idx+2 > len(chunk.data) {
+				continue
+			}
+			var reader, err = zlib.NewReader(bytes.NewReader(chunk.data[idx+2:]))
+			if err != nil {
+				continue
+			}
+			md.ICC, _ = io.ReadAll(reader)
+			reader.Close()
+		case "iTXt":
+			if text, ok := pngXMP(chunk.data); ok {
+				md.XMP = text
+			}
+		}
+	}
+	return md, nil
+}
+
+// pngXMP returns the text of an uncompressed iTXt chunk with the xmp keyword
+func pngXMP(data []byte) ([]byte, bool) {
+	// keyword, null, compression flag, compression method, language tag, null, translated keyword, null, text
+	var parts = bytes.SplitN(data, []byte{0}, 2)
+	if len(parts) != 2 || string(parts[0]) != pngXMPKeyword || len(parts[1]) < 2 || parts[1][0] != 0 {
+		return nil, false
+	}
+	var rest = bytes.SplitN(parts[1][2:], []byte{0}, 3)
+	if len(rest) != 3 {
+		return nil, false
+	}
+	return rest[2], true
+}
+
+// isPNGMetadata reports whether the chunk is one WriteMetadata replaces, sRGB goes too
+// because it must not be combined with an icc profile
+func isPNGMetadata(chunk pngChunk) bool {
+	switch chunk.typ {
+	case "eXIf", "iCCP", "sRGB":
+		return true
+	case "iTXt":
+		var _, ok = pngXMP(chunk.data)
+		return ok
+	}
+	return false
+}
+
+func writePNGMetadata(data []byte, md *Metadata) ([]byte, error) {
+	var chunks, err = splitPNG(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].typ != "IHDR" {
+		return nil, errors.New("png does not start with IHDR")
+	}
+
+	var added []pngChunk
+	if len(md.ICC) > 0 {
+		var compressed bytes.Buffer
+		var writer = zlib.NewWriter(&compressed)
+		if _, err := writer.Write(md.ICC); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		added = append(added, pngChunk{typ: "iCCP", data: append([]byte("ICC Profile\x00\x00"), compressed.Bytes()...)})
+	}
+	if len(md.EXIF) > 0 {
+		added = append(added, pngChunk{typ: "eXIf", data: md.EXIF})
+	}
+	if len(md.XMP) > 0 {
+		added = append(added, pngChunk{typ: "iTXt", data: append([]byte(pngXMPKeyword+"\x00\x00\x00\x00\x00"), md.XMP...)})
+	}
+
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	var write = func(chunk pngChunk) {
+		_ = binary.Write(&out, binary.BigEndian, uint32(len(chunk.data)))
+		var crc = crc32.NewIEEE()
+		crc.Write([]byte(chunk.typ))
+		crc.Write(chunk.data)
+		out.WriteString(chunk.typ)
+		out.Write(chunk.data)
+		_ = binary.Write(&out, binary.BigEndian, crc.Sum32())
+	}
+
+	// everything we add has to come before PLTE and IDAT, right after IHDR is simplest
+	write(chunks[0])
+	for _, chunk := range added {
+		write(chunk)
+	}
+	for _, chunk := range chunks[1:] {
+		if !isPNGMetadata(chunk) {
+			write(chunk)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+func readWebPMetadata(data []byte) (*Metadata, error) {
+	var md = new(Metadata)
+	// RIFF, size, WEBP, then chunks of a fourcc, a little endian size and the data padded to an even size
+	for i := 12; i+8 <= len(data); {
+		var fourcc = string(data[i : i+4])
+		var size = int(binary.LittleEndian.Uint32(data[i+4:]))
+		if size < 0 || i+8+size > len(data) {
+			return nil, errors.New("webp chunk is too long")
+		}
+		var chunk = data[i+8 : i+8+size]
+		switch fourcc {
+		case "EXIF":
+			// some encoders keep the jpeg prefix
+			md.EXIF = bytes.TrimPrefix(chunk, exifPrefix)
+		case "ICCP":
+			md.ICC = chunk
+		case "XMP ":
+			md.XMP = chunk
+		}
+		i += 8 + size + size%2
+	}
+	return md, nil
+}
+
+// xmp keywords are the items of dc:subject, the rating is an attribute or element
+var (
+	xmpSubjectRegex = regexp.MustCompile(`(?s)<dc:subject>\s*<rdf:Bag>(.*?)</rdf:Bag>`)
+	xmpItemRegex    = regexp.MustCompile(`(?s)<rdf:li[^>]*>(.*?)</rdf:li>`)
+	xmpRatingRegex  = regexp.MustCompile(`xmp:Rating(?:="|>)(-?\d+)`)
+)
+
+// xmpPacketBegin and xmpPacketEnd wrap the rdf:Descriptions of an xmp packet
+const (
+	xmpPacketBegin = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>` + "\n" +
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n"
+	xmpPacketEnd = "</rdf:RDF></x:xmpmeta>\n" + `<?xpacket end="w"?>`
+)
+
+// safeXMP builds a new xmp packet with only the keywords and rating, nil when there are neither
+func safeXMP(xmp []byte) []byte {
+	var keywords []string
+	if match := xmpSubjectRegex.FindSubmatch(xmp); match != nil {
+		for _, item := range xmpItemRegex.FindAllSubmatch(match[1], -1) {
+			// the items are still escaped xml, they can be written back as they are
+			keywords = append(keywords, string(item[1]))
+		}
+	}
+	var rating string
+	if match := xmpRatingRegex.FindSubmatch(xmp); match != nil {
+		rating = string(match[1])
+	}
+	if len(keywords) == 0 && rating == "" {
+		return nil
+	}
+
+	var packet strings.Builder
+	packet.WriteString(xmpPacketBegin)
+	packet.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/"`)
+	if rating != "" {
+		packet.WriteString(` xmp:Rating="` + rating + `"`)
+	}
+	packet.WriteString(">\n")
+	if len(keywords) > 0 {
+		packet.WriteString("<dc:subject><rdf:Bag>")
+		for _, keyword := range keywords {
+			packet.WriteString("<rdf:li>" + keyword + "</rdf:li>")
+		}
+		packet.WriteString("</rdf:Bag></dc:subject>\n")
+	}
+	packet.WriteString("</rdf:Description>\n")
+	packet.WriteString(xmpPacketEnd)
+	return []byte(packet.String())
+}
@@ -0,0 +1,153 @@
+package imageupsizer
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testEXIF has camera, date and gps tags the safe subset keeps, and resolution and maker note tags it drops
+func testEXIF(order binary.ByteOrder) []byte {
+	var ascii = func(tag uint16, value string) exifEntry {
+		return exifEntry{tag: tag, typ: 2, count: uint32(len(value) + 1), value: append([]byte(value), 0)}
+	}
+	var short = func(tag uint16, value uint16) exifEntry {
+		var raw = make([]byte, 2)
+		order.PutUint16(raw, value)
+		return exifEntry{tag: tag, typ: 3, count: 1, value: raw}
+	}
+
+	var e = &exifData{
+		order: order,
+		ifd0:  []exifEntry{ascii(0x010F, "Canon"), ascii(0x0110, "Canon EOS 5D"), short(0x0112, 6), short(0x0128, 2)},
+		exif:  []exifEntry{ascii(0x9003, "2023:05:01 12:00:00"), {tag: 0x927C, typ: 7, count: 8, value: []byte("makernot")}},
+		gps:   []exifEntry{ascii(0x0001, "N")},
+	}
+	return e.encode()
+}
+
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmp:Rating="4" xmp:CreatorTool="Lightroom">
+<dc:subject><rdf:Bag><rdf:li>beach</rdf:li><rdf:li>sunset &amp; sea</rdf:li></rdf:Bag></dc:subject>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+func TestParseEXIF(t *testing.T) {
+	t.Parallel()
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var parsed, err = parseEXIF(testEXIF(order))
+		assert.NoError(t, err)
+		assert.Len(t, parsed.ifd0, 6) // the four tags and the exif and gps pointers
+		assert.Len(t, parsed.exif, 2)
+		assert.Len(t, parsed.gps, 1)
+
+		var safe = parsed.safe()
+		assert.Len(t, safe.ifd0, 2)
+		assert.Len(t, safe.exif, 1)
+		assert.Len(t, safe.gps, 1)
+
+		// the safe subset survives a round trip
+		reparsed, err := parseEXIF(safe.encode())
+		assert.NoError(t, err)
+		assert.Equal(t, "Canon EOS 5D\x00", string(reparsed.ifd0[1].value))
+		assert.Equal(t, "2023:05:01 12:00:00\x00", string(reparsed.exif[0].value))
+		assert.Equal(t, "N\x00", string(reparsed.gps[0].value))
+	}
+
+	var _, err = parseEXIF([]byte("II*\x00\xff\xff\xff\xff"))
+	assert.ErrorIs(t, err, errBadEXIF)
+}
+
+func FuzzParseEXIF(f *testing.F) {
+	f.Add(testEXIF(binary.LittleEndian))
+	f.Add(testEXIF(binary.BigEndian))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var parsed, err = parseEXIF(data)
+		if err != nil {
+			return
+		}
+		// whatever parses must encode into something that parses again
+		if _, err := parseEXIF(parsed.encode()); err != nil {
+			t.Errorf("re-encoded exif does not parse: %s", err)
+		}
+	})
+}
+
+func TestSafeXMP(t *testing.T) {
+	t.Parallel()
+
+	var safe = string(safeXMP([]byte(testXMP)))
+	assert.Contains(t, safe, `xmp:Rating="4"`)
+	assert.Contains(t, safe, "<rdf:li>beach</rdf:li><rdf:li>sunset &amp; sea</rdf:li>")
+	assert.NotContains(t, safe, "Lightroom")
+
+	assert.Nil(t, safeXMP([]byte("<x:xmpmeta></x:xmpmeta>")))
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	var md = &Metadata{EXIF: testEXIF(binary.BigEndian), ICC: make([]byte, 70000), XMP: []byte(testXMP)}
+	// large enough to be split over two jpeg segments
+	for i := range md.ICC {
+		md.ICC[i] = byte(i)
+	}
+
+	var dir = t.TempDir()
+	var jpegFile = filepath.Join(dir, "image.jpg")
+	assert.NoError(t, os.WriteFile(jpegFile, encodeJPEG(t, testImage(false), 90).Bytes, 0600))
+	var pngFile = writePNG(t, testImage(true))
+
+	for _, filename := range []string{jpegFile, pngFile} {
+		assert.NoError(t, WriteMetadata(filename, md))
+		var read, err = ReadMetadata(filename)
+		assert.NoError(t, err)
+		assert.Equal(t, md, read, filename)
+		assert.NoError(t, verifyImage(filename))
+
+		// writing again replaces instead of adding
+		assert.NoError(t, WriteMetadata(filename, &Metadata{XMP: []byte("<x/>")}))
+		read, err = ReadMetadata(filename)
+		assert.NoError(t, err)
+		assert.Equal(t, &Metadata{XMP: []byte("<x/>")}, read, filename)
+	}
+}
+
+func TestCopyMetadata(t *testing.T) {
+	t.Parallel()
+
+	var original = filepath.Join(t.TempDir(), "original.jpg")
+	assert.NoError(t, os.WriteFile(original, encodeJPEG(t, testImage(false), 90).Bytes, 0600))
+	var md = &Metadata{EXIF: testEXIF(binary.LittleEndian), ICC: []byte("profile"), XMP: []byte(testXMP)}
+	assert.NoError(t, WriteMetadata(original, md))
+
+	var upsized = writePNG(t, testImage(false))
+
+	assert.NoError(t, CopyMetadata(original, upsized, MetadataAll))
+	var read, err = ReadMetadata(upsized)
+	assert.NoError(t, err)
+	assert.Equal(t, md.ICC, read.ICC)
+	assert.Equal(t, md.XMP, read.XMP)
+	// everything but the orientation of the original, the upsized image has none
+	expected, err := withOrientation(md.EXIF, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, read.EXIF)
+	assert.Less(t, len(read.EXIF), len(md.EXIF))
+
+	assert.NoError(t, CopyMetadata(original, upsized, MetadataSafe))
+	read, err = ReadMetadata(upsized)
+	assert.NoError(t, err)
+	assert.Equal(t, md.Safe(), read)
+	assert.Equal(t, []byte("profile"), read.ICC)
+	assert.Less(t, len(read.EXIF), len(md.EXIF))
+
+	assert.NoError(t, CopyMetadata(original, upsized, MetadataStrip))
+	read, err = ReadMetadata(upsized)
+	assert.NoError(t, err)
+	assert.Equal(t, &Metadata{}, read)
+
+	assert.ErrorIs(t, WriteMetadata(original+".missing", md), os.ErrNotExist)
+}
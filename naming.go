@@ -0,0 +1,163 @@
+package imageupsizer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CollisionPolicy is what MoveToOutput does when the output file already exists.
+type CollisionPolicy string
+
+const (
+	// CollisionSkip leaves the existing file and returns ErrOutputExists
+	CollisionSkip CollisionPolicy = "skip"
+	// CollisionOverwrite replaces the existing file
+	CollisionOverwrite CollisionPolicy = "overwrite"
+	// CollisionSuffix adds -1, -2 ... to the name until it's free
+	CollisionSuffix CollisionPolicy = "suffix"
+)
+
+// DefaultNameTemplate mirrors the input tree and keeps the name of the original, with the extension of the format it was written in
+const DefaultNameTemplate = "{dir}/{name}.{ext}"
+
+// ErrOutputExists is returned by MoveToOutput when the output exists and the policy is CollisionSkip
+var ErrOutputExists = errors.New("output file exists")
+
+// NameFields are the values of the placeholders in a name template:
+// {dir} the directory of the original relative to the input, {name} the name of the original
+// without its extension, {ext} the extension of the upsized image, {origext} the extension of
+// the original, {hash} the first 16 hex digits of the sha256 of the upsized image, {width} and {height} its size.
+type NameFields struct {
+	Dir     string
+	Name    string
+	Ext     string
+	OrigExt string
+	Hash    string
+	Width   int
+	Height  int
+}
+
+var namePlaceholderRegex = regexp.MustCompile(`\{[^}]*\}`)
+
+// NewNameFields collects the fields for naming upsized, the larger version of original. Original is
+// under inputRoot, its directory is left out when inputRoot is empty or does not contain it.
+func NewNameFields(inputRoot, original, upsized string, larger *ImageData) (NameFields, error) {
+	var fields = NameFields{
+		Name:    strings.TrimSuffix(filepath.Base(original), filepath.Ext(original)),
+		Ext:     strings.TrimPrefix(filepath.Ext(upsized), "."),
+		OrigExt: strings.TrimPrefix(filepath.Ext(original), "."),
+		Width:   larger.Width,
+		Height:  larger.Height,
+	}
+	if inputRoot != "" {
+		if rel, err := filepath.Rel(inputRoot, filepath.Dir(original)); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			fields.Dir = rel
+		}
+	}
+
+	var file, err = os.Open(upsized)
+	if err != nil {
+		return fields, fmt.Errorf("error opening file: %s, error: %w", upsized, err)
+	}
+	defer file.Close()
+
+	var hash = sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return fields, fmt.Errorf("error hashing file: %s, error: %w", upsized, err)
+	}
+	fields.Hash = hex.EncodeToString(hash.Sum(nil))[:16]
+	return fields, nil
+}
+
+// ExpandName fills in the placeholders of template, the result is a path relative to the output directory.
+func ExpandName(template string, fields NameFields) (string, error) {
+	var unknown string
+	var name = namePlaceholderRegex.ReplaceAllStringFunc(template, func(placeholder string) string {
+		switch placeholder {
+		case "{dir}":
+			if fields.Dir == "" {
+				return "."
+			}
+			return fields.Dir
+		case "{name}":
+			return fields.Name
+		case "{ext}":
+			return fields.Ext
+		case "{origext}":
+			return fields.OrigExt
+		case "{hash}":
+			return fields.Hash
+		case "{width}":
+			return strconv.Itoa(fields.Width)
+		case "{height}":
+			return strconv.Itoa(fields.Height)
+		default:
+			unknown = placeholder
+			return placeholder
+		}
+	})
+	if unknown != "" {
+		return "", fmt.Errorf("unknown placeholder: %s in name template: %s", unknown, template)
+	}
+
+	name = filepath.Clean(name)
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("name template: %s must stay inside the output directory, got: %s", template, name)
+	}
+	if name == "." || strings.HasSuffix(name, ".") {
+		return "", fmt.Errorf("name template: %s gave an empty file name: %s", template, name)
+	}
+	return name, nil
+}
+
+// ValidateNameTemplate checks template with example values.
+func ValidateNameTemplate(template string) error {
+	var _, err = ExpandName(template, NameFields{Dir: "album", Name: "photo", Ext: "jpg", OrigExt: "png", Hash: "0123456789abcdef", Width: 1, Height: 1})
+	return err
+}
+
+// MoveToOutput renames from to to, creating the directories it needs, and returns the name it was moved to.
+func MoveToOutput(from, to string, policy CollisionPolicy) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+		return "", fmt.Errorf("error creating dir: %s, error: %w", filepath.Dir(to), err)
+	}
+
+	var target = to
+	if policy != CollisionOverwrite {
+		var ext = filepath.Ext(to)
+		var base = strings.TrimSuffix(to, ext)
+		for i := 0; ; i++ {
+			if i > 0 {
+				target = fmt.Sprintf("%s-%d%s", base, i, ext)
+			}
+			// claim the name so workers moving to the same name don't overwrite each other
+			var placeholder, err = os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if os.IsExist(err) {
+				if policy == CollisionSkip {
+					return "", fmt.Errorf("%w: %s", ErrOutputExists, to)
+				}
+				continue
+			} else if err != nil {
+				return "", fmt.Errorf("error creating file: %s, error: %w", target, err)
+			}
+			placeholder.Close()
+			break
+		}
+	}
+
+	if err := os.Rename(from, target); err != nil {
+		if policy != CollisionOverwrite {
+			os.Remove(target)
+		}
+		return "", fmt.Errorf("error renaming: %s to: %s, error: %w", from, target, err)
+	}
+	return target, nil
+}
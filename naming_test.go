@@ -0,0 +1,99 @@
+package imageupsizer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandName(t *testing.T) {
+	t.Parallel()
+
+	var fields = NameFields{Dir: "2023/summer", Name: "IMG_001", Ext: "jpg", OrigExt: "png", Hash: "0123456789abcdef", Width: 4000, Height: 3000}
+	var tests = map[string]string{
+		DefaultNameTemplate:             "2023/summer/IMG_001.jpg",
+		"{dir}/{name}_upsized.{ext}":    "2023/summer/IMG_001_upsized.jpg",
+		"{hash}.{ext}":                  "0123456789abcdef.jpg",
+		"{name}.{origext}":              "IMG_001.png",
+		"{name}_{width}x{height}.{ext}": "IMG_001_4000x3000.jpg",
+		"upsized/{dir}/{name}.{ext}":    "upsized/2023/summer/IMG_001.jpg",
+	}
+	for template, expected := range tests {
+		var name, err = ExpandName(template, fields)
+		assert.NoError(t, err, template)
+		assert.Equal(t, filepath.FromSlash(expected), name, template)
+	}
+
+	// files at the top of the input have no dir
+	var name, err = ExpandName(DefaultNameTemplate, NameFields{Name: "a", Ext: "jpg"})
+	assert.NoError(t, err)
+	assert.Equal(t, "a.jpg", name)
+
+	for _, template := range []string{"{name}.{extension}", "../{name}.{ext}", "/tmp/{name}.{ext}", "", "{name}."} {
+		assert.Error(t, ValidateNameTemplate(template), template)
+	}
+}
+
+func TestNewNameFields(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var upsized = filepath.Join(dir, "download.jpg")
+	assert.NoError(t, os.WriteFile(upsized, []byte("upsized"), 0600))
+	var larger = &ImageData{}
+	larger.Width, larger.Height = 200, 100
+
+	var fields, err = NewNameFields("/photos", "/photos/2023/IMG_001.PNG", upsized, larger)
+	assert.NoError(t, err)
+	// sha256sum of "upsized"
+	assert.Equal(t, NameFields{Dir: "2023", Name: "IMG_001", Ext: "jpg", OrigExt: "PNG", Hash: "c63575ab998ed378", Width: 200, Height: 100}, fields)
+
+	fields, err = NewNameFields("/photos", "/elsewhere/IMG_001.PNG", upsized, larger)
+	assert.NoError(t, err)
+	assert.Empty(t, fields.Dir)
+	fields, err = NewNameFields("", "/photos/2023/IMG_001.PNG", upsized, larger)
+	assert.NoError(t, err)
+	assert.Empty(t, fields.Dir)
+}
+
+func TestMoveToOutput(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var download = func(contents string) string {
+		var file, err = os.CreateTemp(dir, "download-*")
+		assert.NoError(t, err)
+		_, err = file.WriteString(contents)
+		assert.NoError(t, err)
+		assert.NoError(t, file.Close())
+		return file.Name()
+	}
+	var output = filepath.Join(dir, "out", "album", "photo.jpg")
+
+	moved, err := MoveToOutput(download("first"), output, CollisionSkip)
+	assert.NoError(t, err)
+	assert.Equal(t, output, moved)
+
+	var from = download("second")
+	_, err = MoveToOutput(from, output, CollisionSkip)
+	assert.ErrorIs(t, err, ErrOutputExists)
+	assert.Equal(t, "first", string(readFile(t, output)))
+	assert.FileExists(t, from)
+
+	moved, err = MoveToOutput(from, output, CollisionSuffix)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "out", "album", "photo-1.jpg"), moved)
+	assert.Equal(t, "second", string(readFile(t, moved)))
+
+	moved, err = MoveToOutput(download("third"), output, CollisionOverwrite)
+	assert.NoError(t, err)
+	assert.Equal(t, output, moved)
+	assert.Equal(t, "third", string(readFile(t, output)))
+
+	// a failed move does not leave the claimed name behind
+	_, err = MoveToOutput(filepath.Join(dir, "missing"), filepath.Join(dir, "out", "other.jpg"), CollisionSuffix)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoFileExists(t, filepath.Join(dir, "out", "other.jpg"))
+}
@@ -0,0 +1,121 @@
+package imageupsizer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// tagOrientation is the exif tag saying how the stored pixels must be turned to display the image
+const tagOrientation = 0x0112
+
+// orientation is the value of the orientation tag, 1 (as stored) when it's missing or invalid.
+// 2 to 8 are mirrored, rotated by 180, mirrored vertically, transposed, rotated 90 clockwise,
+// transversed and rotated 90 counterclockwise.
+func (e *exifData) orientation() int {
+	for _, entry := range e.ifd0 {
+		if entry.tag != tagOrientation || entry.typ != 3 || len(entry.value) < 2 {
+			continue
+		}
+		if o := int(e.order.Uint16(entry.value)); o >= 1 && o <= 8 {
+			return o
+		}
+	}
+	return 1
+}
+
+// exifOrientation is the orientation in an exif block, 1 when there is none
+func exifOrientation(exif []byte) int {
+	if len(exif) == 0 {
+		return 1
+	}
+	var parsed, err = parseEXIF(exif)
+	if err != nil {
+		return 1
+	}
+	return parsed.orientation()
+}
+
+// orientationOf is the exif orientation of a jpeg, png or webp file
+func orientationOf(contents []byte) int {
+	var md, err = parseMetadata(contents)
+	if err != nil {
+		return 1
+	}
+	return exifOrientation(md.EXIF)
+}
+
+// swapsDimensions reports whether the image is displayed with its width and height swapped
+func swapsDimensions(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// withOrientation sets the orientation tag of an exif block, an orientation of 1 removes it
+func withOrientation(exif []byte, orientation int) ([]byte, error) {
+	if exifOrientation(exif) == orientation {
+		return exif, nil
+	}
+
+	var parsed = &exifData{order: binary.BigEndian}
+	if len(exif) > 0 {
+		var err error
+		if parsed, err = parseEXIF(exif); err != nil {
+			return nil, fmt.Errorf("error parsing exif: %w", err)
+		}
+	}
+
+	parsed.ifd0 = removeTags(parsed.ifd0, tagOrientation)
+	if orientation > 1 {
+		var value = make([]byte, 2)
+		parsed.order.PutUint16(value, uint16(orientation))
+		parsed.ifd0 = append(parsed.ifd0, exifEntry{tag: tagOrientation, typ: 3, count: 1, value: value})
+	}
+	if len(removeTags(parsed.ifd0, tagExifIFD, tagGPSIFD)) == 0 && len(parsed.exif) == 0 && len(parsed.gps) == 0 {
+		return nil, nil
+	}
+	return parsed.encode(), nil
+}
+
+// applyOrientation turns img the way orientation says, so it's displayed right without the exif tag
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	var bounds = img.Bounds()
+	var src = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	var w, h = src.Rect.Dx(), src.Rect.Dy()
+	var dst *image.NRGBA
+	if swapsDimensions(orientation) {
+		dst = image.NewNRGBA(image.Rect(0, 0, h, w))
+	} else {
+		dst = image.NewNRGBA(image.Rect(0, 0, w, h))
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,87 @@
+package imageupsizer
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyOrientation(t *testing.T) {
+	t.Parallel()
+
+	// a 3x2 image with a red top left corner
+	var img = image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+
+	// where the red corner ends up and the size of the turned image
+	var tests = map[int]struct {
+		corner image.Point
+		size   image.Point
+	}{
+		1: {image.Pt(0, 0), image.Pt(3, 2)},
+		2: {image.Pt(2, 0), image.Pt(3, 2)},
+		3: {image.Pt(2, 1), image.Pt(3, 2)},
+		4: {image.Pt(0, 1), image.Pt(3, 2)},
+		5: {image.Pt(0, 0), image.Pt(2, 3)},
+		6: {image.Pt(1, 0), image.Pt(2, 3)},
+		7: {image.Pt(1, 2), image.Pt(2, 3)},
+		8: {image.Pt(0, 2), image.Pt(2, 3)},
+	}
+	for orientation, test := range tests {
+		var turned = applyOrientation(img, orientation)
+		assert.Equal(t, test.size, turned.Bounds().Size(), orientation)
+		var r, _, _, _ = turned.At(test.corner.X, test.corner.Y).RGBA()
+		assert.Equal(t, uint32(0xffff), r, orientation)
+	}
+}
+
+func TestOrientation(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var filename = filepath.Join(dir, "rotated.jpg")
+	var img = image.NewGray(image.Rect(0, 0, 48, 32))
+	assert.NoError(t, os.WriteFile(filename, encodeJPEG(t, img, 90).Bytes, 0600))
+	// testEXIF says the image is rotated by 90 degrees
+	assert.NoError(t, WriteMetadata(filename, &Metadata{EXIF: testEXIF(binary.LittleEndian)}))
+
+	var data, err = GetImageConfigFromFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, data.Orientation)
+	assert.Equal(t, img.Bounds().Dy(), data.Width)
+	assert.Equal(t, img.Bounds().Dx(), data.Height)
+
+	// the png has no exif, the rotation has to be in the pixels
+	var opts = DefaultConvertOptions
+	opts.Format = FormatPNG
+	result, err := ConvertWithOptions(filename, opts)
+	assert.NoError(t, err)
+	converted, err := GetImageConfigFromFile(result.File)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, converted.Orientation)
+	assert.Equal(t, data.Width, converted.Width)
+	assert.Equal(t, data.Height, converted.Height)
+
+	// copying all metadata must not bring the orientation back
+	var original = filepath.Join(dir, "original.jpg")
+	assert.NoError(t, os.WriteFile(original, encodeJPEG(t, img, 90).Bytes, 0600))
+	assert.NoError(t, WriteMetadata(original, &Metadata{EXIF: testEXIF(binary.BigEndian)}))
+	assert.NoError(t, CopyMetadata(original, result.File, MetadataAll))
+	converted, err = GetImageConfigFromFile(result.File)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, converted.Orientation)
+
+	// stripping must not lose the orientation the file itself needs
+	var stored = filepath.Join(dir, "stored.jpg")
+	assert.NoError(t, os.WriteFile(stored, encodeJPEG(t, img, 90).Bytes, 0600))
+	assert.NoError(t, WriteMetadata(stored, &Metadata{EXIF: testEXIF(binary.BigEndian)}))
+	assert.NoError(t, CopyMetadata(original, stored, MetadataStrip))
+	md, err := ReadMetadata(stored)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, exifOrientation(md.EXIF))
+}
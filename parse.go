@@ -0,0 +1,161 @@
+package imageupsizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ParseError is returned by the html parsers when a page does not have the shape they expect.
+// It wraps ErrParse so callers can match it with errors.Is.
+type ParseError struct {
+	// Page names the page being parsed, it is also used as the name of the debug dump
+	Page   string
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrParse.Error(), e.Page, e.Reason)
+}
+
+func (e *ParseError) Unwrap() error {
+	return ErrParse
+}
+
+func newParseError(page, format string, args ...any) *ParseError {
+	return &ParseError{Page: page, Reason: fmt.Sprintf(format, args...)}
+}
+
+// parseHTML builds a dom for the page. The html5 parser accepts any input
+// so the error is only a guard against the reader failing.
+func parseHTML(page, doc string) (*html.Node, error) {
+	var root, err = html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return nil, newParseError(page, "html parse: %s", err.Error())
+	}
+	return root, nil
+}
+
+// walk calls fn on every node below n in document order, stopping early when fn returns false.
+func walk(n *html.Node, fn func(*html.Node) bool) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if !fn(c) || !walk(c, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// findAll returns every element below n that matches.
+func findAll(n *html.Node, match func(*html.Node) bool) []*html.Node {
+	var found []*html.Node
+	walk(n, func(c *html.Node) bool {
+		if c.Type == html.ElementNode && match(c) {
+			found = append(found, c)
+		}
+		return true
+	})
+	return found
+}
+
+// attr returns the value of the attribute or "" if it's not set.
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// hasAttr reports whether the attribute is set, even to "".
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// textContent is the concatenated text of n and everything below it.
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var buf strings.Builder
+	walk(n, func(c *html.Node) bool {
+		if c.Type == html.TextNode {
+			buf.WriteString(c.Data)
+		}
+		return true
+	})
+	return buf.String()
+}
+
+// scripts returns the contents of every inline script, this is where google and facebook embed their data.
+func scripts(root *html.Node) []string {
+	var contents []string
+	for _, s := range findAll(root, func(n *html.Node) bool { return n.DataAtom == atom.Script }) {
+		if text := textContent(s); text != "" {
+			contents = append(contents, text)
+		}
+	}
+	return contents
+}
+
+// imageTuple is the ["url", height, width] array google uses for every image in its result data.
+type imageTuple struct {
+	URL    string
+	Height int
+	Width  int
+}
+
+// imageTuples decodes every ["http...", h, w] array in js in the order they appear.
+func imageTuples(js string) []imageTuple {
+	var tuples []imageTuple
+	for offset := 0; offset < len(js); {
+		var idx = strings.Index(js[offset:], `["http`)
+		if idx == -1 {
+			break
+		}
+		var start = offset + idx
+		offset = start + 1
+
+		var raw []json.RawMessage
+		if err := json.NewDecoder(strings.NewReader(js[start:])).Decode(&raw); err != nil || len(raw) != 3 {
+			continue
+		}
+		var tuple imageTuple
+		if json.Unmarshal(raw[0], &tuple.URL) != nil || json.Unmarshal(raw[1], &tuple.Height) != nil || json.Unmarshal(raw[2], &tuple.Width) != nil {
+			continue
+		}
+		tuples = append(tuples, tuple)
+	}
+	return tuples
+}
+
+// jsonObjectsWithKey decodes every json object in js that starts with the given key,
+// e.g. `{"uri":`, and returns them in the order they appear.
+func jsonObjectsWithKey[T any](js, key string) []T {
+	var marker = `{"` + key + `":`
+	var values []T
+	for offset := 0; offset < len(js); {
+		var idx = strings.Index(js[offset:], marker)
+		if idx == -1 {
+			break
+		}
+		var start = offset + idx
+		offset = start + 1
+
+		var value T
+		if err := json.NewDecoder(strings.NewReader(js[start:])).Decode(&value); err != nil {
+			continue
+		}
+		values = append(values, value)
+	}
+	return values
+}
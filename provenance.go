@@ -0,0 +1,222 @@
+package imageupsizer
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.szostok.io/version"
+)
+
+// SidecarFormat is the format of the provenance file written next to an upsized image.
+type SidecarFormat string
+
+const (
+	SidecarJSON SidecarFormat = "json"
+	SidecarXMP  SidecarFormat = "xmp"
+)
+
+// provenanceNamespace is the xmp namespace of the provenance properties
+const provenanceNamespace = "https://github.com/kmulvey/imageupsizer/ns/provenance/1.0/"
+
+// Provenance records where an upsized image came from.
+type Provenance struct {
+	// OriginalPath and OriginalSHA512 are set when the original was a file, OriginalURL when it was a url
+	OriginalPath   string `json:"original_path,omitempty"`
+	OriginalSHA512 string `json:"original_sha512,omitempty"`
+	OriginalURL    string `json:"original_url,omitempty"`
+	SourceURL      string `json:"source_url"`
+	// SourcePage is the page the image was found on, see ImageData.SourcePage
+	SourcePage     string    `json:"source_page,omitempty"`
+	Provider       string    `json:"provider,omitempty"`
+	OriginalWidth  int       `json:"original_width"`
+	OriginalHeight int       `json:"original_height"`
+	Width          int       `json:"width"`
+	Height         int       `json:"height"`
+	Time           time.Time `json:"time"`
+	ToolVersion    string    `json:"tool_version"`
+}
+
+// NewProvenance records that larger replaces original, the original file is hashed when it has a LocalPath.
+func NewProvenance(original, larger *ImageData) (*Provenance, error) {
+	var p = &Provenance{
+		OriginalPath:   original.LocalPath,
+		SourceURL:      larger.URL,
+		SourcePage:     larger.SourcePage,
+		Provider:       larger.Provider,
+		OriginalWidth:  original.Width,
+		OriginalHeight: original.Height,
+		Width:          larger.Width,
+		Height:         larger.Height,
+		Time:           time.Now().UTC(),
+		ToolVersion:    version.Get().Version,
+	}
+	if original.LocalPath == "" {
+		p.OriginalURL = original.URL
+		return p, nil
+	}
+
+	var file, err = os.Open(original.LocalPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %s, error: %w", original.LocalPath, err)
+	}
+	defer file.Close()
+
+	// a plain sha512 of the file so it can be checked with sha512sum
+	var hash = sha512.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, fmt.Errorf("error hashing file: %s, error: %w", original.LocalPath, err)
+	}
+	p.OriginalSHA512 = hex.EncodeToString(hash.Sum(nil))
+	return p, nil
+}
+
+// WriteSidecar writes p next to filename, as filename.json or filename.xmp, and returns the name of the sidecar.
+func WriteSidecar(filename string, p *Provenance, format SidecarFormat) (string, error) {
+	var contents []byte
+	switch format {
+	case SidecarJSON:
+		var err error
+		if contents, err = json.MarshalIndent(p, "", "  "); err != nil {
+			return "", fmt.Errorf("error encoding provenance: %s, error: %w", filename, err)
+		}
+	case SidecarXMP:
+		contents = p.xmpPacket()
+	default:
+		return "", fmt.Errorf("unknown sidecar format: %s", format)
+	}
+
+	var sidecar = filename + "." + string(format)
+	var err = writeAtomic(sidecar, func(file *os.File) error {
+		_, err := file.Write(contents)
+		return err
+	}, nil)
+	return sidecar, err
+}
+
+// ReadSidecar reads the provenance WriteSidecar wrote next to filename, as json or xmp.
+func ReadSidecar(filename string) (*Provenance, error) {
+	var p = new(Provenance)
+	var contents, err = os.ReadFile(filename + "." + string(SidecarJSON))
+	if err == nil {
+		if err := json.Unmarshal(contents, p); err != nil {
+			return nil, fmt.Errorf("error decoding provenance: %s, error: %w", filename, err)
+		}
+		return p, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading sidecar: %s, error: %w", filename, err)
+	}
+
+	contents, err = os.ReadFile(filename + "." + string(SidecarXMP))
+	if err != nil {
+		return nil, fmt.Errorf("error reading sidecar: %s, error: %w", filename, err)
+	}
+	var description = provenanceDescriptionRegex.Find(contents)
+	if description == nil {
+		return nil, fmt.Errorf("no provenance in sidecar: %s", filename+"."+string(SidecarXMP))
+	}
+	for _, attr := range provenanceAttributeRegex.FindAllSubmatch(description, -1) {
+		var value = html.UnescapeString(string(attr[2]))
+		var number, _ = strconv.Atoi(value)
+		switch string(attr[1]) {
+		case "OriginalPath":
+			p.OriginalPath = value
+		case "OriginalSHA512":
+			p.OriginalSHA512 = value
+		case "OriginalURL":
+			p.OriginalURL = value
+		case "SourceURL":
+			p.SourceURL = value
+		case "SourcePage":
+			p.SourcePage = value
+		case "Provider":
+			p.Provider = value
+		case "OriginalWidth":
+			p.OriginalWidth = number
+		case "OriginalHeight":
+			p.OriginalHeight = number
+		case "Width":
+			p.Width = number
+		case "Height":
+			p.Height = number
+		case "Time":
+			p.Time, _ = time.Parse(time.RFC3339, value)
+		case "ToolVersion":
+			p.ToolVersion = value
+		}
+	}
+	return p, nil
+}
+
+// EmbedProvenance adds p to the xmp of a jpeg or png file, the rest of its xmp is kept
+// and the provenance of an earlier run is replaced.
+func EmbedProvenance(filename string, p *Provenance) error {
+	var md, err = ReadMetadata(filename)
+	if err != nil {
+		return err
+	}
+
+	var description = p.xmpDescription()
+	var xmp = provenanceDescriptionRegex.ReplaceAllString(string(md.XMP), "")
+	if idx := strings.LastIndex(xmp, "</rdf:RDF>"); idx != -1 {
+		md.XMP = []byte(xmp[:idx] + description + xmp[idx:])
+	} else {
+		md.XMP = p.xmpPacket()
+	}
+	return WriteMetadata(filename, md)
+}
+
+// provenanceDescriptionRegex matches the description EmbedProvenance adds
+var provenanceDescriptionRegex = regexp.MustCompile(`(?s)<rdf:Description[^>]*xmlns:iu="` + regexp.QuoteMeta(provenanceNamespace) + `".*?/>\n?`)
+
+// provenanceAttributeRegex matches the properties in the description
+var provenanceAttributeRegex = regexp.MustCompile(`iu:(\w+)="([^"]*)"`)
+
+// xmpDescription is p as an rdf:Description with the properties as attributes
+func (p *Provenance) xmpDescription() string {
+	var attrs = []struct{ name, value string }{
+		{"OriginalPath", p.OriginalPath},
+		{"OriginalSHA512", p.OriginalSHA512},
+		{"OriginalURL", p.OriginalURL},
+		{"SourceURL", p.SourceURL},
+		{"SourcePage", p.SourcePage},
+		{"Provider", p.Provider},
+		{"OriginalWidth", strconv.Itoa(p.OriginalWidth)},
+		{"OriginalHeight", strconv.Itoa(p.OriginalHeight)},
+		{"Width", strconv.Itoa(p.Width)},
+		{"Height", strconv.Itoa(p.Height)},
+		{"Time", p.Time.Format(time.RFC3339)},
+		{"ToolVersion", p.ToolVersion},
+	}
+
+	var description strings.Builder
+	description.WriteString(`<rdf:Description rdf:about="" xmlns:iu="` + provenanceNamespace + `"`)
+	for _, attr := range attrs {
+		if attr.value == "" {
+			continue
+		}
+		description.WriteString("\n  iu:" + attr.name + `="`)
+		_ = xml.EscapeText(&description, []byte(attr.value))
+		description.WriteString(`"`)
+	}
+	description.WriteString("/>\n")
+	return description.String()
+}
+
+// xmpPacket is a whole xmp packet with only the provenance
+func (p *Provenance) xmpPacket() []byte {
+	var packet strings.Builder
+	packet.WriteString(xmpPacketBegin)
+	packet.WriteString(p.xmpDescription())
+	packet.WriteString(xmpPacketEnd)
+	return []byte(packet.String())
+}
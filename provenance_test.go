@@ -0,0 +1,72 @@
+package imageupsizer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvenance(t *testing.T) {
+	t.Parallel()
+
+	var original, err = GetImageConfigFromFile("test.jpg")
+	assert.NoError(t, err)
+	var larger = &ImageData{
+		URL:        "https://example.com/photo.jpg?w=2000&h=1000",
+		SourcePage: "https://example.com/gallery",
+		Provider:   "google",
+	}
+	larger.Width, larger.Height = original.Width*2, original.Height*2
+
+	provenance, err := NewProvenance(original, larger)
+	assert.NoError(t, err)
+	// sha512sum test.jpg
+	assert.Equal(t, "17cb20fa7162012c484864bb8786e91704570d10c8a67e4479fb15deb54dd3a56f5d32f6eb642001a43790bf235c289bb8a83d6613690ffaaa093399ebcd469b", provenance.OriginalSHA512)
+	assert.Empty(t, provenance.OriginalURL)
+	assert.NotEmpty(t, provenance.ToolVersion)
+
+	var output = filepath.Join(t.TempDir(), "photo.jpg")
+	assert.NoError(t, os.WriteFile(output, encodeJPEG(t, testImage(false), 90).Bytes, 0600))
+
+	sidecar, err := WriteSidecar(output, provenance, SidecarJSON)
+	assert.NoError(t, err)
+	assert.Equal(t, output+".json", sidecar)
+	var read Provenance
+	assert.NoError(t, json.Unmarshal(readFile(t, sidecar), &read))
+	assert.True(t, provenance.Time.Equal(read.Time))
+	read.Time = provenance.Time
+	assert.Equal(t, *provenance, read)
+
+	sidecar, err = WriteSidecar(output, provenance, SidecarXMP)
+	assert.NoError(t, err)
+	assert.Contains(t, string(readFile(t, sidecar)), `iu:SourceURL="https://example.com/photo.jpg?w=2000&amp;h=1000"`)
+
+	// the json sidecar is read before the xmp one, they record the same
+	for _, format := range []SidecarFormat{SidecarJSON, SidecarXMP} {
+		var read, err = ReadSidecar(output)
+		assert.NoError(t, err, format)
+		assert.WithinDuration(t, provenance.Time, read.Time, time.Second, format)
+		read.Time = provenance.Time
+		assert.Equal(t, provenance, read, format)
+		assert.NoError(t, os.Remove(output+"."+string(format)))
+	}
+	_, err = ReadSidecar(output)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// the keywords and rating stay, the provenance of an earlier run is replaced
+	assert.NoError(t, WriteMetadata(output, &Metadata{XMP: safeXMP([]byte(testXMP))}))
+	assert.NoError(t, EmbedProvenance(output, provenance))
+	assert.NoError(t, EmbedProvenance(output, provenance))
+	md, err := ReadMetadata(output)
+	assert.NoError(t, err)
+	var xmp = string(md.XMP)
+	assert.Equal(t, 1, strings.Count(xmp, provenanceNamespace))
+	assert.Contains(t, xmp, "<rdf:li>beach</rdf:li>")
+	assert.Contains(t, xmp, `iu:SourcePage="https://example.com/gallery"`)
+	assert.NoError(t, verifyImage(output))
+}
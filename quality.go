@@ -0,0 +1,339 @@
+package imageupsizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Selection is how the larger image is picked when there are several candidates.
+type Selection string
+
+const (
+	// SelectLargest picks the image with the most pixels
+	SelectLargest Selection = "largest"
+	// SelectBestQuality picks the image with the highest Score
+	SelectBestQuality Selection = "quality"
+)
+
+// selection is used wherever candidates are compared
+var selection = SelectLargest
+
+// SetSelection sets how candidates are compared, it is not safe to call while searches are in flight.
+func SetSelection(s Selection) {
+	selection = s
+}
+
+// Score is how good a candidate image is, not just how big.
+type Score struct {
+	Area int `json:"area"`
+	// NativeArea is the area of the resolution the image really has detail for, see EstimateNativeResolution
+	NativeArea int `json:"native_area"`
+	// JPEGQuality is the quality the image was saved with estimated from its quantization
+	// tables, 1 to 100. It is 0 for other formats.
+	JPEGQuality int `json:"jpeg_quality"`
+	// Sharpness is the mean absolute laplacian of the luma, how much fine detail there is
+	Sharpness float64 `json:"sharpness"`
+	// Detail compares the fine detail to the detail at half the resolution. Sharp native photos
+	// are around 0.75, upscaled and very heavily compressed images have much less fine detail and score 0.5 or lower.
+	Detail float64 `json:"detail"`
+	// BytesPerPixel is the file size over the area, very heavily compressed images have little of it
+	BytesPerPixel float64 `json:"bytes_per_pixel"`
+	// Total is the native area discounted by everything above, it is what SelectBestQuality compares
+	Total float64 `json:"total"`
+}
+
+const (
+	// nativeDetail is the Detail at which an image is considered to have all the detail its size promises
+	nativeDetail = 0.7
+	// upscaledDetail is the Detail of a typical upscale, images at or below it keep little of their area
+	upscaledDetail = 0.45
+	// goodBytesPerPixel is the BytesPerPixel above which compression is not held against an image
+	goodBytesPerPixel = 0.15
+	// goodJPEGQuality is the JPEGQuality above which compression is not held against an image
+	goodJPEGQuality = 85
+)
+
+// ScoreImage decodes img.Bytes and scores it.
+func ScoreImage(img *ImageData) (Score, error) {
+	var score = Score{Area: img.Area}
+
+	decoded, _, err := image.Decode(bytes.NewReader(img.Bytes))
+	if err != nil {
+		return score, fmt.Errorf("error decoding image: %s, error: %w", img.URL, err)
+	}
+	var bounds = decoded.Bounds()
+	score.Area = bounds.Dx() * bounds.Dy()
+
+	if img.Extension == "jpeg" {
+		score.JPEGQuality = jpegQuality(img.Bytes)
+	}
+
+	var size = img.FileSize
+	if size <= 0 {
+		size = int64(len(img.Bytes))
+	}
+	if score.Area > 0 {
+		score.BytesPerPixel = float64(size) / float64(score.Area)
+	}
+
+	var nativeWidth, nativeHeight = nativeResolution(decoded)
+	score.NativeArea = nativeWidth * nativeHeight
+
+	var sharpness, half float64
+	var tiles = detailTiles(bounds)
+	for _, tile := range tiles {
+		var gray = luma(decoded, tile)
+		sharpness += gray.laplacian()
+		half += gray.half().laplacian()
+	}
+	score.Sharpness = sharpness / float64(len(tiles))
+	if half > 0 {
+		score.Detail = sharpness / half
+	}
+
+	score.Total = float64(score.NativeArea) * detailFactor(score.Detail) * compressionFactor(score)
+	return score, nil
+}
+
+// detailFactor discounts images with less detail than their size promises. An upscale
+// adds pixels but no detail so its area is discounted back to about what it was upscaled from.
+func detailFactor(detail float64) float64 {
+	var factor = math.Max(0, math.Min(1, (detail-upscaledDetail)/(nativeDetail-upscaledDetail)))
+	return math.Max(0.05, factor*factor)
+}
+
+// compressionFactor discounts heavily compressed images, at most by half.
+func compressionFactor(s Score) float64 {
+	var factor = math.Min(1, s.BytesPerPixel/goodBytesPerPixel)
+	if s.JPEGQuality > 0 {
+		factor = math.Max(factor, math.Min(1, float64(s.JPEGQuality)/goodJPEGQuality))
+	}
+	return 0.5 + factor/2
+}
+
+// SelectImage picks the best of the images, nil images are skipped. When the selection is
+// SelectBestQuality images are compared by their Score, it is computed for the images that don't
+// have one yet, and images that can't be scored lose.
+func SelectImage(sel Selection, images ...*ImageData) *ImageData {
+	var best *ImageData
+	var bestScore = -1.0
+	for _, img := range images {
+		if img == nil {
+			continue
+		}
+
+		var score = float64(img.Area)
+		if sel == SelectBestQuality {
+			score = 0
+			if img.Score == nil {
+				s, err := ScoreImage(img)
+				if err != nil {
+					log.Tracef("[%s] error scoring image: %s", img.URL, err)
+				} else {
+					log.Tracef("[%s] score: %+v", img.URL, s)
+					img.Score = &s
+				}
+			}
+			if img.Score != nil {
+				score = img.Score.Total
+			}
+		}
+
+		if score > bestScore {
+			best = img
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// standardLuminance is the luminance quantization table from the jpeg spec in zigzag order,
+// encoders scale it by the quality so comparing against it gives back the quality.
+var standardLuminance = [64]float64{
+	16, 11, 12, 14, 12, 10, 16, 14, 13, 14, 18, 17, 16, 19, 24, 40,
+	26, 24, 22, 22, 24, 49, 35, 37, 29, 40, 58, 51, 61, 60, 57, 51,
+	56, 55, 64, 72, 92, 78, 64, 68, 87, 69, 55, 56, 80, 109, 81, 87,
+	95, 98, 103, 104, 103, 62, 77, 113, 121, 112, 100, 120, 92, 101, 103, 99,
+}
+
+// jpegQuality estimates the quality a jpeg was saved with from its luminance quantization
+// table, assuming the encoder scaled the standard table the way libjpeg does. It returns 0
+// when the file has no luminance table.
+func jpegQuality(data []byte) int {
+	var table = luminanceTable(data)
+	if table == nil {
+		return 0
+	}
+
+	var sum float64
+	for i, q := range table {
+		sum += q * 100 / standardLuminance[i]
+	}
+	var scale = sum / 64
+
+	var quality float64
+	if scale <= 100 {
+		quality = (200 - scale) / 2
+	} else {
+		quality = 5000 / scale
+	}
+	return int(math.Round(math.Max(1, math.Min(100, quality))))
+}
+
+// luminanceTable finds the quantization table with id 0 in the jpeg headers
+func luminanceTable(data []byte) []float64 {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		var marker = data[i+1]
+		// start of scan, the headers are over
+		if marker == 0xDA {
+			return nil
+		}
+		var length = int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil
+		}
+		var segment = data[i+4 : i+2+length]
+		i += 2 + length
+
+		if marker != 0xDB {
+			continue
+		}
+		// a segment can hold several tables: precision and id, then 64 8-bit or 16-bit values
+		for len(segment) > 0 {
+			var precision, id = segment[0] >> 4, segment[0] & 0x0F
+			var size = 64
+			if precision == 1 {
+				size = 128
+			}
+			if len(segment) < 1+size {
+				return nil
+			}
+
+			if id == 0 {
+				var table = make([]float64, 64)
+				for j := range table {
+					if precision == 1 {
+						table[j] = float64(binary.BigEndian.Uint16(segment[1+2*j:]))
+					} else {
+						table[j] = float64(segment[1+j])
+					}
+				}
+				return table
+			}
+			segment = segment[1+size:]
+		}
+	}
+	return nil
+}
+
+// grayImage is the luma of an image as floats, it's what the detail measures work on
+type grayImage struct {
+	width, height int
+	pix           []float64
+}
+
+// detailTiles are the parts of an image the detail is measured on: a grid of tiles spread
+// over images larger than analysisSize, the same number of pixels as the native resolution
+// analysis but from all over the image as some parts of a photo have little detail to measure.
+func detailTiles(bounds image.Rectangle) []image.Rectangle {
+	const grid = 4
+	const tile = analysisSize / grid
+	if bounds.Dx() <= analysisSize && bounds.Dy() <= analysisSize {
+		return []image.Rectangle{bounds}
+	}
+
+	var tiles = make([]image.Rectangle, 0, grid*grid)
+	for i := 0; i < grid; i++ {
+		for j := 0; j < grid; j++ {
+			var x = bounds.Min.X + max(0, bounds.Dx()-tile)*(2*i+1)/(2*grid)
+			var y = bounds.Min.Y + max(0, bounds.Dy()-tile)*(2*j+1)/(2*grid)
+			tiles = append(tiles, image.Rect(x, y, x+tile, y+tile).Intersect(bounds))
+		}
+	}
+	return tiles
+}
+
+// luma is the luma of the part of img in rect, jpegs and the images the decoders
+// return most often are read straight from their pixels
+func luma(img image.Image, rect image.Rectangle) *grayImage {
+	rect = rect.Intersect(img.Bounds())
+	var g = &grayImage{width: rect.Dx(), height: rect.Dy(), pix: make([]float64, rect.Dx()*rect.Dy())}
+	for y := 0; y < g.height; y++ {
+		var row = g.pix[y*g.width : (y+1)*g.width]
+		switch img := img.(type) {
+		case *image.YCbCr:
+			// Y is the luma already
+			var start = img.YOffset(rect.Min.X, rect.Min.Y+y)
+			for x, v := range img.Y[start : start+g.width] {
+				row[x] = float64(v)
+			}
+		case *image.Gray:
+			var start = img.PixOffset(rect.Min.X, rect.Min.Y+y)
+			for x, v := range img.Pix[start : start+g.width] {
+				row[x] = float64(v)
+			}
+		case *image.NRGBA:
+			var pix = img.Pix[img.PixOffset(rect.Min.X, rect.Min.Y+y):]
+			for x := range row {
+				var p = pix[4*x : 4*x+4]
+				row[x] = rgbLuma(float64(p[0]), float64(p[1]), float64(p[2])) * float64(p[3]) / 255
+			}
+		case *image.RGBA:
+			var pix = img.Pix[img.PixOffset(rect.Min.X, rect.Min.Y+y):]
+			for x := range row {
+				var p = pix[4*x : 4*x+4]
+				row[x] = rgbLuma(float64(p[0]), float64(p[1]), float64(p[2]))
+			}
+		default:
+			for x := range row {
+				var r, gr, b, _ = img.At(rect.Min.X+x, rect.Min.Y+y).RGBA()
+				row[x] = rgbLuma(float64(r), float64(gr), float64(b)) / 257
+			}
+		}
+	}
+	return g
+}
+
+func rgbLuma(r, g, b float64) float64 {
+	return 0.299*r + 0.587*g + 0.114*b
+}
+
+// half averages every 2x2 block
+func (g *grayImage) half() *grayImage {
+	var h = &grayImage{width: g.width / 2, height: g.height / 2}
+	h.pix = make([]float64, h.width*h.height)
+	for y := 0; y < h.height; y++ {
+		for x := 0; x < h.width; x++ {
+			var i = 2*y*g.width + 2*x
+			h.pix[y*h.width+x] = (g.pix[i] + g.pix[i+1] + g.pix[i+g.width] + g.pix[i+g.width+1]) / 4
+		}
+	}
+	return h
+}
+
+// laplacian is the mean absolute 4 neighbour laplacian
+func (g *grayImage) laplacian() float64 {
+	if g.width < 3 || g.height < 3 {
+		return 0
+	}
+	var sum float64
+	for y := 1; y < g.height-1; y++ {
+		for x := 1; x < g.width-1; x++ {
+			var i = y*g.width + x
+			sum += math.Abs(4*g.pix[i] - g.pix[i-1] - g.pix[i+1] - g.pix[i-g.width] - g.pix[i+g.width])
+		}
+	}
+	return sum / float64((g.width-2)*(g.height-2))
+}
@@ -0,0 +1,123 @@
+package imageupsizer
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/draw"
+)
+
+// encodeJPEG makes an ImageData the way downloadImage would
+func encodeJPEG(t testing.TB, img image.Image, quality int) *ImageData {
+	t.Helper()
+
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}))
+
+	var bounds = img.Bounds()
+	return &ImageData{
+		Bytes:     buf.Bytes(),
+		Extension: "jpeg",
+		Config:    image.Config{Width: bounds.Dx(), Height: bounds.Dy()},
+		Area:      bounds.Dx() * bounds.Dy(),
+		FileSize:  int64(buf.Len()),
+	}
+}
+
+func readTestImage(t *testing.T) image.Image {
+	t.Helper()
+
+	var data, err = os.ReadFile("test.jpg")
+	assert.NoError(t, err)
+	img, _, err := image.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	return img
+}
+
+func TestJPEGQuality(t *testing.T) {
+	t.Parallel()
+
+	var img = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for _, quality := range []int{30, 50, 75, 90, 95} {
+		assert.Equal(t, quality, jpegQuality(encodeJPEG(t, img, quality).Bytes))
+	}
+	assert.Equal(t, 0, jpegQuality([]byte("not a jpeg")))
+	assert.Equal(t, 0, jpegQuality([]byte{0xFF, 0xD8, 0xFF, 0xDB, 0x00}))
+}
+
+func FuzzJPEGQuality(f *testing.F) {
+	f.Add(encodeJPEG(f, image.NewRGBA(image.Rect(0, 0, 8, 8)), 75).Bytes)
+	f.Add([]byte{0xFF, 0xD8, 0xFF, 0xDB, 0x00, 0x43, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var quality = jpegQuality(data)
+		if quality < 0 || quality > 100 {
+			t.Errorf("quality out of range: %d", quality)
+		}
+	})
+}
+
+func TestSelectImage(t *testing.T) {
+	t.Parallel()
+
+	var img = readTestImage(t)
+	var bounds = img.Bounds()
+
+	var upscaled = image.NewRGBA(image.Rect(0, 0, bounds.Dx()*2, bounds.Dy()*2))
+	draw.CatmullRom.Scale(upscaled, upscaled.Bounds(), img, bounds, draw.Src, nil)
+
+	var native = encodeJPEG(t, img, 90)
+	var upscale = encodeJPEG(t, upscaled, 90)
+
+	assert.Equal(t, upscale, SelectImage(SelectLargest, native, upscale, nil))
+	assert.Equal(t, native, SelectImage(SelectBestQuality, native, upscale, nil))
+
+	nativeScore, err := ScoreImage(native)
+	assert.NoError(t, err)
+	upscaleScore, err := ScoreImage(upscale)
+	assert.NoError(t, err)
+	assert.Equal(t, 90, nativeScore.JPEGQuality)
+	assert.Greater(t, nativeScore.Detail, upscaleScore.Detail)
+	assert.Greater(t, nativeScore.Sharpness, upscaleScore.Sharpness)
+
+	// the score is kept on the image
+	assert.Equal(t, nativeScore, *native.Score)
+
+	// images that can't be decoded lose
+	assert.Equal(t, native, SelectImage(SelectBestQuality, &ImageData{Area: 1 << 30}, native))
+
+	// unless they come from the cache with the score they had when they were found
+	var cached = &ImageData{Area: 1 << 30, Score: &Score{Total: nativeScore.Total * 2}}
+	assert.Equal(t, cached, SelectImage(SelectBestQuality, cached, native))
+}
+
+func TestLuma(t *testing.T) {
+	t.Parallel()
+
+	var img = readTestImage(t)
+	var bounds = img.Bounds()
+	var nrgba = image.NewNRGBA(bounds)
+	draw.Draw(nrgba, bounds, img, bounds.Min, draw.Src)
+
+	// the decoded jpeg and the nrgba are read from their pixels, they must match the generic path
+	var rect = image.Rect(100, 50, 300, 150)
+	var generic = luma(struct{ image.Image }{nrgba}, rect)
+	for _, fast := range []image.Image{img, nrgba} {
+		var g = luma(fast, rect)
+		assert.Equal(t, generic.width, g.width)
+		assert.Equal(t, generic.height, g.height)
+		assert.InDeltaSlice(t, generic.pix, g.pix, 2)
+	}
+
+	// large images are measured in tiles from all over them
+	var tiles = detailTiles(bounds)
+	assert.Len(t, tiles, 16)
+	for _, tile := range tiles {
+		assert.True(t, tile.In(bounds))
+		assert.Equal(t, analysisSize/4, tile.Dx())
+	}
+	assert.Equal(t, []image.Rectangle{image.Rect(0, 0, 100, 100)}, detailTiles(image.Rect(0, 0, 100, 100)))
+}
@@ -0,0 +1,377 @@
+package imageupsizer
+
+import (
+	"math"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Resolver knows how a particular site serves its images and how to get from a
+// thumbnail or a page on that site to the original size image.
+type Resolver struct {
+	Name string
+	// Match reports whether the resolver handles the url
+	Match func(u *url.URL) bool
+	// Rewrite turns u into urls of larger versions of the same image without fetching anything,
+	// best first. It is optional and may return nothing when u is already the original.
+	Rewrite func(u *url.URL) []*url.URL
+	// FromHTML finds the image in the html served at pageURL when the site returns a page instead of an image. Optional.
+	FromHTML func(pageURL *url.URL, doc string) (*url.URL, error)
+	// Render means FromHTML needs the page rendered in chrome because the image is added by javascript
+	Render bool
+}
+
+var (
+	resolversMu sync.RWMutex
+	resolvers   = []Resolver{
+		facebookResolver,
+		instagramResolver,
+		pinterestResolver,
+		flickrResolver,
+		twitterResolver,
+		redditResolver,
+		imgurResolver,
+		wikimediaResolver,
+	}
+)
+
+// RegisterResolver adds a resolver for another site. Resolvers registered later
+// take precedence so a built in one can be replaced by registering one that matches the same urls.
+func RegisterResolver(r Resolver) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+
+	resolvers = append([]Resolver{r}, resolvers...)
+}
+
+// resolverFor returns the resolver that handles u, if any.
+func resolverFor(u *url.URL) *Resolver {
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+
+	for i := range resolvers {
+		if resolvers[i].Match(u) {
+			var r = resolvers[i]
+			return &r
+		}
+	}
+	return nil
+}
+
+// hostIs reports whether u is on one of the domains or their subdomains.
+func hostIs(u *url.URL, domains ...string) bool {
+	var host = strings.ToLower(u.Hostname())
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// withPath returns a copy of u with a new path and no query.
+func withPath(u *url.URL, p string) *url.URL {
+	var rewritten = *u
+	rewritten.Path = p
+	rewritten.RawPath = ""
+	rewritten.RawQuery = ""
+	return &rewritten
+}
+
+// metaImage returns the og:image or twitter:image of a page, most sites put their full size image there.
+func metaImage(pageURL *url.URL, doc, page string) (*url.URL, error) {
+	var root, err = parseHTML(page, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, property := range []string{"og:image:secure_url", "og:image", "twitter:image:src", "twitter:image"} {
+		var metas = findAll(root, func(n *html.Node) bool {
+			return n.DataAtom == atom.Meta && (attr(n, "property") == property || attr(n, "name") == property)
+		})
+		for _, meta := range metas {
+			if content := attr(meta, "content"); content != "" {
+				return resolveAgainst(page, pageURL, content)
+			}
+		}
+	}
+	return nil, newParseError(page, "no og:image found")
+}
+
+// resolveAgainst resolves a possibly relative or protocol relative link found on pageURL.
+func resolveAgainst(page string, pageURL *url.URL, link string) (*url.URL, error) {
+	var u, err = url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return nil, newParseError(page, "bad link: %s", err.Error())
+	}
+	if pageURL != nil {
+		u = pageURL.ResolveReference(u)
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return nil, newParseError(page, "link is not absolute: %s", link)
+	}
+	return u, nil
+}
+
+var facebookRegex = regexp.MustCompile(`fbsbx|facebook`)
+
+var facebookResolver = Resolver{
+	Name: "facebook",
+	Match: func(u *url.URL) bool {
+		return facebookRegex.MatchString(u.String())
+	},
+	FromHTML: func(_ *url.URL, doc string) (*url.URL, error) {
+		return findImageInFacebookHtml(doc)
+	},
+	Render: true,
+}
+
+var instagramPostRegex = regexp.MustCompile(`^/(?:[\w.]+/)?(p|reel|tv)/([\w-]+)/?$`)
+
+var instagramResolver = Resolver{
+	Name: "instagram",
+	Match: func(u *url.URL) bool {
+		return hostIs(u, "instagram.com", "cdninstagram.com")
+	},
+	// a post page can be turned straight into its largest image, cdn urls are signed and can't be touched
+	Rewrite: func(u *url.URL) []*url.URL {
+		var match = instagramPostRegex.FindStringSubmatch(u.Path)
+		if match == nil || !hostIs(u, "instagram.com") {
+			return nil
+		}
+		var rewritten = withPath(u, "/p/"+match[2]+"/media/")
+		rewritten.RawQuery = "size=l"
+		return []*url.URL{rewritten}
+	},
+	FromHTML: func(pageURL *url.URL, doc string) (*url.URL, error) {
+		type resource struct {
+			Src    string `json:"src"`
+			Width  int    `json:"config_width"`
+			Height int    `json:"config_height"`
+		}
+
+		var root, err = parseHTML("instagram", doc)
+		if err != nil {
+			return nil, err
+		}
+		var largest resource
+		for _, js := range scripts(root) {
+			for _, r := range jsonObjectsWithKey[resource](js, "src") {
+				if r.Width*r.Height > largest.Width*largest.Height {
+					largest = r
+				}
+			}
+		}
+		if largest.Src != "" {
+			return resolveAgainst("instagram", pageURL, largest.Src)
+		}
+		return metaImage(pageURL, doc, "instagram")
+	},
+}
+
+// pinimg paths start with the size: /236x/, /474x/, /736x/, /1200x/, /564x846/ ...
+var pinterestSizeRegex = regexp.MustCompile(`^/(\d+)x\d*/`)
+
+var pinterestResolver = Resolver{
+	Name: "pinterest",
+	Match: func(u *url.URL) bool {
+		return hostIs(u, "pinimg.com", "pinterest.com")
+	},
+	Rewrite: func(u *url.URL) []*url.URL {
+		var match = pinterestSizeRegex.FindStringSubmatch(u.Path)
+		if match == nil || !hostIs(u, "pinimg.com") {
+			return nil
+		}
+		var originals = withPath(u, pinterestSizeRegex.ReplaceAllString(u.Path, "/originals/"))
+		// not every pin has an original, 736x always exists but is only larger than the smaller sizes
+		if width, _ := strconv.Atoi(match[1]); width >= 736 {
+			return []*url.URL{originals}
+		}
+		return []*url.URL{originals, withPath(u, pinterestSizeRegex.ReplaceAllString(u.Path, "/736x/"))}
+	},
+	FromHTML: func(pageURL *url.URL, doc string) (*url.URL, error) {
+		return metaImage(pageURL, doc, "pinterest")
+	},
+}
+
+// static flickr urls are /server/id_secret_size.ext, the size suffix is optional
+var flickrStaticRegex = regexp.MustCompile(`^(/\d+/\d+_[0-9a-f]+)(?:_([a-z0-9]{1,2}))?\.(jpg|jpeg|png|gif)$`)
+
+// flickrSizes is the long side of each flickr size suffix, no suffix is 500 and o the original is the largest
+var flickrSizes = map[string]int{
+	"s": 75, "t": 100, "q": 150, "m": 240, "n": 320, "w": 400, "": 500, "z": 640, "c": 800,
+	"b": 1024, "h": 1600, "k": 2048, "3k": 3072, "4k": 4096, "f": 4096, "5k": 5120, "6k": 6144, "o": math.MaxInt,
+}
+
+var flickrResolver = Resolver{
+	Name: "flickr",
+	Match: func(u *url.URL) bool {
+		return hostIs(u, "staticflickr.com", "flickr.com")
+	},
+	// _o is the original upload, it's only served when the photo's original is public.
+	// _k and _h usually have their own secret so _b is the largest we can be sure of.
+	// Only the sizes larger than the one in u are tried.
+	Rewrite: func(u *url.URL) []*url.URL {
+		var match = flickrStaticRegex.FindStringSubmatch(u.Path)
+		if match == nil || !hostIs(u, "staticflickr.com") {
+			return nil
+		}
+		var candidates []*url.URL
+		for _, size := range []string{"o", "k", "h", "b"} {
+			if flickrSizes[size] > flickrSizes[match[2]] {
+				candidates = append(candidates, withPath(u, match[1]+"_"+size+"."+match[3]))
+			}
+		}
+		return candidates
+	},
+	FromHTML: func(pageURL *url.URL, doc string) (*url.URL, error) {
+		type size struct {
+			URL    string `json:"displayUrl"`
+			Width  int    `json:"width"`
+			Height int    `json:"height"`
+		}
+
+		var root, err = parseHTML("flickr", doc)
+		if err != nil {
+			return nil, err
+		}
+		var largest size
+		for _, js := range scripts(root) {
+			for _, s := range jsonObjectsWithKey[size](js, "displayUrl") {
+				if s.Width*s.Height > largest.Width*largest.Height {
+					largest = s
+				}
+			}
+		}
+		if largest.URL != "" {
+			return resolveAgainst("flickr", pageURL, largest.URL)
+		}
+		return metaImage(pageURL, doc, "flickr")
+	},
+}
+
+// old style twitter urls put the size after a colon: /media/ID.jpg:large
+var twitterLegacySizeRegex = regexp.MustCompile(`^(/media/[\w-]+\.\w+)(:\w+)$`)
+
+var twitterResolver = Resolver{
+	Name: "twitter",
+	Match: func(u *url.URL) bool {
+		return hostIs(u, "twimg.com", "twitter.com", "x.com")
+	},
+	Rewrite: func(u *url.URL) []*url.URL {
+		if !hostIs(u, "pbs.twimg.com") || !strings.HasPrefix(u.Path, "/media/") {
+			return nil
+		}
+
+		if match := twitterLegacySizeRegex.FindStringSubmatch(u.Path); match != nil {
+			if match[2] == ":orig" {
+				return nil
+			}
+			return []*url.URL{withPath(u, match[1]+":orig")}
+		}
+
+		var query = u.Query()
+		if query.Get("name") == "orig" {
+			return nil
+		}
+		if query.Get("format") == "" {
+			var ext = strings.TrimPrefix(path.Ext(u.Path), ".")
+			if ext == "" {
+				return nil
+			}
+			query.Set("format", ext)
+		}
+		query.Set("name", "orig")
+		var rewritten = withPath(u, strings.TrimSuffix(u.Path, path.Ext(u.Path)))
+		rewritten.RawQuery = query.Encode()
+		return []*url.URL{rewritten}
+	},
+	FromHTML: func(pageURL *url.URL, doc string) (*url.URL, error) {
+		return metaImage(pageURL, doc, "twitter")
+	},
+	Render: true,
+}
+
+var redditResolver = Resolver{
+	Name: "reddit",
+	Match: func(u *url.URL) bool {
+		return hostIs(u, "redd.it", "reddit.com", "redditmedia.com")
+	},
+	// preview.redd.it/ID.jpg?width=640&... is a resized copy of i.redd.it/ID.jpg
+	Rewrite: func(u *url.URL) []*url.URL {
+		if !hostIs(u, "preview.redd.it") {
+			return nil
+		}
+		var rewritten = withPath(u, u.Path)
+		rewritten.Host = "i.redd.it"
+		return []*url.URL{rewritten}
+	},
+	FromHTML: func(pageURL *url.URL, doc string) (*url.URL, error) {
+		return metaImage(pageURL, doc, "reddit")
+	},
+}
+
+// imgur ids are 5 or 7 characters, thumbnails add one of s, b, t, m, l, h to a 7 character id
+var imgurThumbRegex = regexp.MustCompile(`^/(\w{7})[sbtmlh]\.(\w+)$`)
+var imgurPageRegex = regexp.MustCompile(`^/(\w{5}|\w{7})/?$`)
+
+var imgurResolver = Resolver{
+	Name: "imgur",
+	Match: func(u *url.URL) bool {
+		return hostIs(u, "imgur.com")
+	},
+	Rewrite: func(u *url.URL) []*url.URL {
+		if match := imgurThumbRegex.FindStringSubmatch(u.Path); match != nil && hostIs(u, "i.imgur.com") {
+			return []*url.URL{withPath(u, "/"+match[1]+"."+match[2])}
+		}
+		if match := imgurPageRegex.FindStringSubmatch(u.Path); match != nil && !hostIs(u, "i.imgur.com") {
+			var rewritten = withPath(u, "/"+match[1]+".jpg")
+			rewritten.Host = "i.imgur.com"
+			return []*url.URL{rewritten}
+		}
+		return nil
+	},
+	FromHTML: func(pageURL *url.URL, doc string) (*url.URL, error) {
+		return metaImage(pageURL, doc, "imgur")
+	},
+}
+
+// thumbnails are /wikipedia/commons/thumb/4/40/File.jpg/800px-File.jpg, the original is /wikipedia/commons/4/40/File.jpg
+var wikimediaThumbRegex = regexp.MustCompile(`^(/[\w-]+/[\w-]+)/thumb(/\w/\w\w/[^/]+)/[^/]+$`)
+
+var wikimediaResolver = Resolver{
+	Name: "wikimedia",
+	Match: func(u *url.URL) bool {
+		return hostIs(u, "wikimedia.org", "wikipedia.org")
+	},
+	Rewrite: func(u *url.URL) []*url.URL {
+		var match = wikimediaThumbRegex.FindStringSubmatch(u.Path)
+		if match == nil || !hostIs(u, "upload.wikimedia.org") {
+			return nil
+		}
+		return []*url.URL{withPath(u, match[1]+match[2])}
+	},
+	FromHTML: func(pageURL *url.URL, doc string) (*url.URL, error) {
+		var root, err = parseHTML("wikimedia", doc)
+		if err != nil {
+			return nil, err
+		}
+		// file pages link the original right under the preview
+		for _, div := range findAll(root, func(n *html.Node) bool {
+			return strings.Contains(" "+attr(n, "class")+" ", " fullImageLink ")
+		}) {
+			var links = findAll(div, func(n *html.Node) bool { return n.DataAtom == atom.A && attr(n, "href") != "" })
+			if len(links) > 0 {
+				return resolveAgainst("wikimedia", pageURL, attr(links[0], "href"))
+			}
+		}
+		return metaImage(pageURL, doc, "wikimedia")
+	},
+}
@@ -0,0 +1,169 @@
+package imageupsizer
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolverRewrite(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		resolver string
+		in       string
+		out      []string
+	}{
+		{"pinterest", "https://i.pinimg.com/236x/8f/3a/2b/8f3a2b6f.jpg", []string{"https://i.pinimg.com/originals/8f/3a/2b/8f3a2b6f.jpg", "https://i.pinimg.com/736x/8f/3a/2b/8f3a2b6f.jpg"}},
+		{"pinterest", "https://i.pinimg.com/736x/8f/3a/2b/8f3a2b6f.jpg", []string{"https://i.pinimg.com/originals/8f/3a/2b/8f3a2b6f.jpg"}},
+		{"pinterest", "https://i.pinimg.com/1200x/8f/3a/2b/8f3a2b6f.jpg", []string{"https://i.pinimg.com/originals/8f/3a/2b/8f3a2b6f.jpg"}},
+		{"pinterest", "https://i.pinimg.com/564x846/8f/3a/2b/8f3a2b6f.jpg", []string{"https://i.pinimg.com/originals/8f/3a/2b/8f3a2b6f.jpg", "https://i.pinimg.com/736x/8f/3a/2b/8f3a2b6f.jpg"}},
+		{"pinterest", "https://i.pinimg.com/originals/8f/3a/2b/8f3a2b6f.jpg", nil},
+		{"twitter", "https://pbs.twimg.com/media/FgH1x2aXkAEa1b2?format=jpg&name=small", []string{"https://pbs.twimg.com/media/FgH1x2aXkAEa1b2?format=jpg&name=orig"}},
+		{"twitter", "https://pbs.twimg.com/media/FgH1x2aXkAEa1b2.png", []string{"https://pbs.twimg.com/media/FgH1x2aXkAEa1b2?format=png&name=orig"}},
+		{"twitter", "https://pbs.twimg.com/media/FgH1x2aXkAEa1b2.jpg:large", []string{"https://pbs.twimg.com/media/FgH1x2aXkAEa1b2.jpg:orig"}},
+		{"twitter", "https://pbs.twimg.com/media/FgH1x2aXkAEa1b2?format=jpg&name=orig", nil},
+		{"flickr", "https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_m.jpg", []string{
+			"https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_o.jpg",
+			"https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_k.jpg",
+			"https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_h.jpg",
+			"https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_b.jpg",
+		}},
+		{"flickr", "https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e.jpg", []string{
+			"https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_o.jpg",
+			"https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_k.jpg",
+			"https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_h.jpg",
+			"https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_b.jpg",
+		}},
+		{"flickr", "https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_h.jpg", []string{
+			"https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_o.jpg",
+			"https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_k.jpg",
+		}},
+		{"flickr", "https://live.staticflickr.com/65535/53012345678_1a2b3c4d5e_o.jpg", nil},
+		{"reddit", "https://preview.redd.it/abc123def.jpg?width=640&crop=smart&auto=webp&s=0123", []string{"https://i.redd.it/abc123def.jpg"}},
+		{"imgur", "https://i.imgur.com/AbCdEfGm.jpg", []string{"https://i.imgur.com/AbCdEfG.jpg"}},
+		{"imgur", "https://imgur.com/AbCdEfG", []string{"https://i.imgur.com/AbCdEfG.jpg"}},
+		{"imgur", "https://i.imgur.com/AbCdEfG.jpg", nil},
+		{"instagram", "https://www.instagram.com/p/CxYz123AbC/", []string{"https://www.instagram.com/p/CxYz123AbC/media/?size=l"}},
+		{"wikimedia", "https://upload.wikimedia.org/wikipedia/commons/thumb/4/40/Sunflower_sky_backdrop.jpg/800px-Sunflower_sky_backdrop.jpg", []string{"https://upload.wikimedia.org/wikipedia/commons/4/40/Sunflower_sky_backdrop.jpg"}},
+		{"wikimedia", "https://upload.wikimedia.org/wikipedia/commons/4/40/Sunflower_sky_backdrop.jpg", nil},
+	}
+
+	for _, test := range tests {
+		var in, err = url.Parse(test.in)
+		assert.NoError(t, err)
+
+		var r = resolverFor(in)
+		require.NotNil(t, r, test.in)
+		assert.Equal(t, test.resolver, r.Name, test.in)
+
+		var out []string
+		for _, u := range r.Rewrite(in) {
+			out = append(out, u.String())
+		}
+		assert.Equal(t, test.out, out, test.in)
+	}
+
+	var unknown, err = url.Parse("https://example.com/image.jpg")
+	assert.NoError(t, err)
+	assert.Nil(t, resolverFor(unknown))
+}
+
+func TestResolverFromHTML(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		page    string
+		fixture string
+		out     string
+	}{
+		{"https://commons.wikimedia.org/wiki/File:Sunflower_sky_backdrop.jpg", "wikimedia_file.html", "https://upload.wikimedia.org/wikipedia/commons/4/40/Sunflower_sky_backdrop.jpg"},
+		{"https://www.pinterest.com/pin/123456789/", "pinterest_pin.html", "https://i.pinimg.com/736x/8f/3a/2b/8f3a2b6f1c2d4e5f60718293a4b5c6d7.jpg"},
+		{"https://www.flickr.com/photos/someone/53012345678/", "flickr_photo.html", "https://live.staticflickr.com/65535/53012345678_9f8e7d6c5b_o.jpg"},
+		{"https://www.instagram.com/p/CxYz123AbC/", "instagram_post.html", "https://scontent.cdninstagram.com/v/t51.2885-15/e35/123_n.jpg?oh=3"},
+	}
+
+	for _, test := range tests {
+		var page, err = url.Parse(test.page)
+		assert.NoError(t, err)
+
+		var r = resolverFor(page)
+		if !assert.NotNil(t, r, test.page) {
+			continue
+		}
+		image, err := r.FromHTML(page, readFixture(t, test.fixture))
+		assert.NoError(t, err, test.page)
+		assert.Equal(t, test.out, image.String(), test.page)
+	}
+}
+
+func TestGetImagePageLoop(t *testing.T) {
+	t.Parallel()
+
+	// every page links to the next one, /loop/a and /loop/b link to each other
+	var links = map[string]string{"/loop/a": "/loop/b", "/loop/b": "/loop/a", "/1": "/2", "/2": "/3", "/3": "/4", "/4": "/5"}
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<a href="%s">next</a>`, links[r.URL.Path])
+	}))
+	defer server.Close()
+
+	var serverURL, err = url.Parse(server.URL)
+	require.NoError(t, err)
+	RegisterResolver(Resolver{
+		Name:  "loop",
+		Match: func(u *url.URL) bool { return u.Host == serverURL.Host },
+		FromHTML: func(pageURL *url.URL, doc string) (*url.URL, error) {
+			return pageURL.Parse(strings.TrimSuffix(strings.TrimPrefix(doc, `<a href="`), `">next</a>`))
+		},
+	})
+
+	_, err = getImage(server.URL + "/loop/a")
+	assert.ErrorContains(t, err, "links back to")
+	_, err = getImage(server.URL + "/1")
+	assert.ErrorContains(t, err, "pages from an image")
+}
+
+func TestGetImageRewriteLarger(t *testing.T) {
+	t.Parallel()
+
+	var sizes = map[string]int{"/small.jpg": 16, "/medium.jpg": 32, "/large.jpg": 64}
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var size, ok = sizes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write(encodeJPEG(t, image.NewGray(image.Rect(0, 0, size, size)), 90).Bytes)
+	}))
+	defer server.Close()
+
+	// every image is rewritten to one that's missing, a smaller and a larger one
+	var serverURL, err = url.Parse(server.URL)
+	require.NoError(t, err)
+	RegisterResolver(Resolver{
+		Name:  "sizes",
+		Match: func(u *url.URL) bool { return u.Host == serverURL.Host },
+		Rewrite: func(u *url.URL) []*url.URL {
+			return []*url.URL{withPath(u, "/missing.jpg"), withPath(u, "/small.jpg"), withPath(u, "/large.jpg")}
+		},
+	})
+
+	img, err := getImage(server.URL + "/medium.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/large.jpg", img.URL)
+
+	// the largest image is never swapped for a smaller rewrite
+	img, err = getImage(server.URL + "/large.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/large.jpg", img.URL)
+	assert.Equal(t, 64, img.Width)
+}
@@ -12,13 +12,32 @@ import (
 
 	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/chromedp"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 var urlRegex = regexp.MustCompile(`(http|ftp|https):\/\/([\w_-]+(?:(?:\.[\w_-]+)+))([\w.,@?^=%&:\/~+#-]*[\w@?^=%&\/~+#-])`)
 var OtherSizesNotAvailableError = errors.New("No other sizes of this image found.")
 var NoMatchesError = errors.New("Looks like there aren’t any matches for your search")
 
-func scrape(url string, linkFn findUrlFunc) (*url.URL, error) {
+// localizeString is locale.localize for urls that have not been parsed yet.
+func localizeString(link string) string {
+	var u, err = url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return currentLocale().localize(u).String()
+}
+
+// scrape renders url in chrome and parses the page with parseFn.
+func scrape[T any](url string, parseFn func(string) (T, error)) (T, error) {
+	var none T
+	url = localizeString(url)
+
+	// the workers share a limited number of browsers
+	limits.acquireBrowser()
+	defer limits.releaseBrowser()
+
 	// create chrome instance
 	ctx, cancel := chromedp.NewContext(
 		context.Background(),
@@ -29,89 +48,241 @@ func scrape(url string, linkFn findUrlFunc) (*url.URL, error) {
 	ctx, cancel = context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
-	// navigate to a page, wait for an element, click
-	var html string
+	// share the session cookies with chrome and get past the consent wall if we land on it
 	err := chromedp.Run(ctx,
 		network.Enable(),
+		network.SetExtraHTTPHeaders(network.Headers{"Accept-Language": currentLocale().acceptLanguage()}),
+		chromedp.ActionFunc(setChromeCookies),
 		chromedp.Navigate(url),
+	)
+	if err != nil {
+		return none, err
+	}
+	if err := acceptConsentInChrome(ctx, url); err != nil {
+		return none, err
+	}
+
+	// navigate to a page, wait for an element, click
+	var html string
+	err = chromedp.Run(ctx,
 		chromedp.InnerHTML(`html`, &html),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			return getChromeCookies(ctx, url, "https://"+consentHost+"/")
+		}),
 	)
 	if err != nil {
-		return nil, err
+		return none, err
 	}
 
-	return linkFn(html)
+	parsed, err := parseFn(html)
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		if dumpErr := dumpHTML(parseErr.Page, html); dumpErr != nil {
+			return none, fmt.Errorf("%w, %s", err, dumpErr.Error())
+		}
+	}
+	return parsed, err
 }
 
-type findUrlFunc func(string) (*url.URL, error)
-
-func findLargestImageLinkInHtml(html string) (*url.URL, error) {
-	// cast a wide net around the link so we make sure we get it
-	var firstDataIDRegex = regexp.MustCompile(`Image Results.*data-id="[a-zA-Z0-9_-]*"`)
-	var firstDataID = firstDataIDRegex.FindString(html)
-	var dataIDRegex = regexp.MustCompile(`data-id="[a-zA-Z0-9_-]*"`)
-	var dataID = dataIDRegex.FindString(firstDataID)
-	dataID = strings.ReplaceAll(dataID, "data-id=", "")
-	dataID = strings.ReplaceAll(dataID, `"`, "")
-	var jsBlockRegex = regexp.MustCompile(dataID + ".*" + dataID)
-	var jsBlock = jsBlockRegex.FindAllString(html, 2)
-	if len(jsBlock) < 2 {
-		return nil, errors.New("did not find enough urls in the js block")
-	}
-
-	var urls = urlRegex.FindAllString(jsBlock[1], 2)
-	if len(urls) < 2 {
-		if regexp.MustCompile("Looks like there aren’t any matches for your search").MatchString(html) {
+var googleBaseURL = &url.URL{Scheme: "https", Host: "www.google.com"}
+
+// findImageResultsInHtml returns the full size image of every result on the all sizes page,
+// in the order google lists them.
+func findImageResultsInHtml(doc string) ([]imageTuple, error) {
+	const page = "largest_image"
+
+	var root, err = parseHTML(page, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var dataIDs = resultIDs(root)
+	if len(dataIDs) == 0 {
+		if containsMessage(doc, noMatchesMessages) {
 			return nil, NoMatchesError
 		}
+		return nil, newParseError(page, "no result with a data-id found")
+	}
 
-		var err = os.WriteFile("largest_image.html", []byte(html), os.ModePerm)
-		if err != nil {
-			return nil, fmt.Errorf("could not dump to largest_image.html, err: %w", err)
+	// the data of each result is in a script, the first ["url", h, w] after its id
+	// is the thumbnail and the second is the full size image
+	var js = scripts(root)
+	var results []imageTuple
+	var seen = make(map[string]bool)
+	for _, dataID := range dataIDs {
+		for _, script := range js {
+			var idx = strings.Index(script, dataID)
+			if idx == -1 {
+				continue
+			}
+			var tuples = imageTuples(script[idx:])
+			if len(tuples) == 0 {
+				continue
+			}
+			tuples = tuples[:min(2, len(tuples))]
+
+			var largest = tuples[0]
+			for _, tuple := range tuples[1:] {
+				if tuple.Height*tuple.Width > largest.Height*largest.Width {
+					largest = tuple
+				}
+			}
+			if _, err := parseAbsoluteURL(page, largest.URL); err == nil && !seen[largest.URL] {
+				seen[largest.URL] = true
+				results = append(results, largest)
+			}
+			break
 		}
+	}
 
-		return nil, errors.New("did not find enough urls")
+	if len(results) > 0 {
+		return results, nil
 	}
+	if containsMessage(doc, noMatchesMessages) {
+		return nil, NoMatchesError
+	}
+	return nil, newParseError(page, "no image urls found for result: %s", dataIDs[0])
+}
+
+// resultIDs are the data-ids of the results in the image grid, they carry their index as data-ri.
+// This does not depend on the language the page is in. When google drops data-ri all the data-ids in the page are used.
+func resultIDs(root *html.Node) []string {
+	var all, results []string
+	walk(root, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || attr(n, "data-id") == "" {
+			return true
+		}
+		all = append(all, attr(n, "data-id"))
+		if hasAttr(n, "data-ri") {
+			results = append(results, attr(n, "data-id"))
+		}
+		return true
+	})
 
-	return url.Parse(urls[1])
+	if len(results) > 0 {
+		return results
+	}
+	return all
 }
-func findAllSizesLinkInHtml(html string) (*url.URL, error) {
-	// cast a wide net around the link so we make sure we get it
-	var wideLinkRegex = regexp.MustCompile(`\/search\?tbs=simg:.*>All sizes`)
-	var wideLink = wideLinkRegex.FindString(html)
-	var index = strings.Index(wideLink, `"`)
-	if index == -1 {
-		if regexp.MustCompile(`No other sizes of this image found.`).MatchString(html) {
+
+func findAllSizesLinkInHtml(doc string) (*url.URL, error) {
+	const page = "all_sizes"
+
+	var root, err = parseHTML(page, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var links = findAll(root, func(n *html.Node) bool {
+		return n.DataAtom == atom.A && strings.Contains(attr(n, "href"), "tbs=simg:")
+	})
+	if len(links) == 0 {
+		if containsMessage(doc, otherSizesMessages) {
 			return nil, OtherSizesNotAvailableError
 		}
+		return nil, newParseError(page, "all sizes link not found")
+	}
 
-		var err = os.WriteFile("all_sizes.html", []byte(html), os.ModePerm)
-		if err != nil {
-			return nil, fmt.Errorf("could not dump to all_sizes.html, err: %w", err)
+	var link = links[0]
+	for _, l := range links {
+		if strings.Contains(textContent(l), "All sizes") {
+			link = l
+			break
 		}
+	}
+
+	return resolveGoogleURL(page, attr(link, "href"))
+}
+
+func findImageSourceLinkInHtml(doc string) (*url.URL, error) {
+	const page = "image_source"
 
-		return nil, errors.New("wide link not found in html, html dumped to all_sizes.html")
+	var root, err = parseHTML(page, doc)
+	if err != nil {
+		return nil, err
 	}
-	var link = wideLink[:index]
-	link = strings.ReplaceAll(link, "&amp;", "&")
 
-	return url.Parse("https://www.google.com" + link)
+	var links = findAll(root, func(n *html.Node) bool {
+		return n.DataAtom == atom.A && strings.Contains(attr(n, "href"), "tbs=sbi:")
+	})
+	if len(links) > 0 {
+		return resolveGoogleURL(page, attr(links[0], "href"))
+	}
+
+	// the link is not always rendered, but it's always somewhere in the embedded data
+	var link = imageSourceRegex.FindString(doc)
+	if link == "" {
+		return nil, newParseError(page, "image source link not found")
+	}
+	return parseAbsoluteURL(page, link)
 }
 
-func findImageSourceLinkInHtml(html string) (*url.URL, error) {
-	var linkRegex = regexp.MustCompile(`https:\/\/www\.google\.com\/search\?tbs=sbi:[a-zA-Z0-9_-]*`)
-	var link = linkRegex.FindString(html)
+var imageSourceRegex = regexp.MustCompile(`https:\/\/www\.google\.com\/search\?tbs=sbi:[a-zA-Z0-9_-]*`)
 
-	return url.Parse(link)
+// fbImage is the {"uri":...,"width":...,"height":...} object facebook embeds for each photo
+type fbImage struct {
+	URI    string `json:"uri"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
 }
 
-func findImageInFacebookHtml(html string) (*url.URL, error) {
-	html = strings.TrimSpace(html)
-	var wideURLRegex = regexp.MustCompile(`additional_profile_has_taggable_products.*accessibility_caption`)
-	var js = wideURLRegex.FindString(html)
+func findImageInFacebookHtml(doc string) (*url.URL, error) {
+	const page = "facebook"
 
-	var begin = strings.Index(js, `{"uri":"`) + 8
-	var end = strings.Index(js, `","width"`)
+	var root, err = parseHTML(page, doc)
+	if err != nil {
+		return nil, err
+	}
 
-	return url.Parse(strings.ReplaceAll(js[begin:end], "\\/", "/"))
+	var largest fbImage
+	for _, js := range scripts(root) {
+		// the photo being viewed sits between these two keys, narrow down to it when we can
+		if begin := strings.Index(js, "additional_profile_has_taggable_products"); begin != -1 {
+			js = js[begin:]
+			if end := strings.Index(js, "accessibility_caption"); end != -1 {
+				js = js[:end]
+			}
+		}
+
+		for _, img := range jsonObjectsWithKey[fbImage](js, "uri") {
+			if strings.HasPrefix(img.URI, "http") && img.Width*img.Height >= largest.Width*largest.Height {
+				largest = img
+			}
+		}
+	}
+
+	if largest.URI == "" {
+		return nil, newParseError(page, "image uri not found")
+	}
+	return parseAbsoluteURL(page, largest.URI)
+}
+
+// resolveGoogleURL resolves links like /search?tbs=... against google.
+func resolveGoogleURL(page, link string) (*url.URL, error) {
+	var u, err = url.Parse(link)
+	if err != nil {
+		return nil, newParseError(page, "bad link: %s", err.Error())
+	}
+	return googleBaseURL.ResolveReference(u), nil
+}
+
+// parseAbsoluteURL parses link and makes sure it can actually be requested.
+func parseAbsoluteURL(page, link string) (*url.URL, error) {
+	var u, err = url.Parse(link)
+	if err != nil {
+		return nil, newParseError(page, "bad link: %s", err.Error())
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return nil, newParseError(page, "link is not absolute: %s", link)
+	}
+	return u, nil
+}
+
+// dumpHTML saves a page that could not be parsed so the new layout can be inspected.
+func dumpHTML(page, doc string) error {
+	var filename = page + ".html"
+	if err := os.WriteFile(filename, []byte(doc), 0600); err != nil {
+		return fmt.Errorf("could not dump to %s, err: %w", filename, err)
+	}
+	return nil
 }
@@ -0,0 +1,154 @@
+package imageupsizer
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixtures are pages captured from google and facebook with the identifying bits trimmed
+var fixtures = []string{"upload_response.html", "image_source.html", "all_sizes.html", "largest_image.html", "facebook.html"}
+
+func readFixture(tb testing.TB, name string) string {
+	tb.Helper()
+
+	var contents, err = os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		tb.Fatalf("reading fixture %s: %s", name, err)
+	}
+	return string(contents)
+}
+
+// addFixtures seeds the fuzz corpus with every fixture, the parsers
+// should survive each other's pages as well as their own.
+func addFixtures(f *testing.F) {
+	f.Helper()
+
+	for _, name := range fixtures {
+		f.Add(readFixture(f, name))
+	}
+}
+
+func TestFindImageResultsInHtml(t *testing.T) {
+	t.Parallel()
+
+	var results, err = findImageResultsInHtml(readFixture(t, "largest_image.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, []imageTuple{
+		{URL: "https://upload.wikimedia.org/wikipedia/commons/4/40/Sunflower_sky_backdrop.jpg", Height: 2848, Width: 4288},
+		{URL: "https://example.com/sunflower-small.jpg", Height: 400, Width: 600},
+	}, results)
+
+	_, err = findImageResultsInHtml(`<html><body>Looks like there aren’t any matches for your search</body></html>`)
+	assert.ErrorIs(t, err, NoMatchesError)
+
+	_, err = findImageResultsInHtml(`<div data-id="abc"></div>`)
+	assert.ErrorIs(t, err, ErrParse)
+}
+
+func TestFindAllSizesLinkInHtml(t *testing.T) {
+	t.Parallel()
+
+	var link, err = findAllSizesLinkInHtml(readFixture(t, "all_sizes.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, "www.google.com", link.Host)
+	assert.Equal(t, "sunflower", link.Query().Get("q"))
+	assert.Contains(t, link.Query().Get("tbs"), "simg:CAESmgIJUsaQ5ZZ8Z8Ea-gELEKjU2AQaBAgVCAoMCxCwjKcIGmIK")
+
+	_, err = findAllSizesLinkInHtml(`<p>No other sizes of this image found.</p>`)
+	assert.ErrorIs(t, err, OtherSizesNotAvailableError)
+
+	_, err = findAllSizesLinkInHtml(`<p>nothing here</p>`)
+	assert.ErrorIs(t, err, ErrParse)
+}
+
+func TestFindImageSourceLinkInHtml(t *testing.T) {
+	t.Parallel()
+
+	var link, err = findImageSourceLinkInHtml(readFixture(t, "image_source.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, "www.google.com", link.Host)
+	assert.True(t, strings.HasPrefix(link.Query().Get("tbs"), "sbi:AMhZZisq0HVY2M2VTYrjNW8bROLlDyIz0OY2"))
+
+	_, err = findImageSourceLinkInHtml(`<p>nothing here</p>`)
+	assert.ErrorIs(t, err, ErrParse)
+}
+
+func TestFindImageInFacebookHtml(t *testing.T) {
+	t.Parallel()
+
+	var link, err = findImageInFacebookHtml(readFixture(t, "facebook.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/v/t39.30808-6/417503_n.jpg", link.Path)
+
+	// this used to panic slicing with missing markers
+	_, err = findImageInFacebookHtml(`additional_profile_has_taggable_products accessibility_caption`)
+	assert.ErrorIs(t, err, ErrParse)
+}
+
+// checkParserResult fails the fuzz run if a parser returned something other than a url or one of our errors.
+func checkParserResult(t *testing.T, link *url.URL, err error) {
+	t.Helper()
+
+	if err != nil {
+		if !errors.Is(err, ErrParse) && !errors.Is(err, NoMatchesError) && !errors.Is(err, OtherSizesNotAvailableError) {
+			t.Fatalf("unexpected error type: %v", err)
+		}
+		return
+	}
+	if link == nil {
+		t.Fatal("nil url without an error")
+	}
+}
+
+func FuzzFindImageResultsInHtml(f *testing.F) {
+	addFixtures(f)
+	f.Add(`<div data-id="a"></div><script>a["http</script>`)
+	f.Fuzz(func(t *testing.T, doc string) {
+		var results, err = findImageResultsInHtml(doc)
+		if err != nil {
+			checkParserResult(t, nil, err)
+			return
+		}
+		if len(results) == 0 {
+			t.Fatal("no results without an error")
+		}
+		for _, res := range results {
+			if _, err := url.ParseRequestURI(res.URL); err != nil {
+				t.Fatalf("result is not a url: %s, error: %s", res.URL, err)
+			}
+		}
+	})
+}
+
+func FuzzFindAllSizesLinkInHtml(f *testing.F) {
+	addFixtures(f)
+	f.Add(`<a href="tbs=simg:%zz">All sizes</a>`)
+	f.Fuzz(func(t *testing.T, doc string) {
+		var link, err = findAllSizesLinkInHtml(doc)
+		checkParserResult(t, link, err)
+	})
+}
+
+func FuzzFindImageSourceLinkInHtml(f *testing.F) {
+	addFixtures(f)
+	f.Add(`<a href="https://www.google.com/search?tbs=sbi:%">x</a>`)
+	f.Fuzz(func(t *testing.T, doc string) {
+		var link, err = findImageSourceLinkInHtml(doc)
+		checkParserResult(t, link, err)
+	})
+}
+
+func FuzzFindImageInFacebookHtml(f *testing.F) {
+	addFixtures(f)
+	f.Add(`accessibility_caption additional_profile_has_taggable_products`)
+	f.Fuzz(func(t *testing.T, doc string) {
+		var link, err = findImageInFacebookHtml(doc)
+		checkParserResult(t, link, err)
+	})
+}
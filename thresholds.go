@@ -0,0 +1,104 @@
+package imageupsizer
+
+import (
+	"fmt"
+	"math"
+)
+
+// Thresholds decide whether a larger image is worth using, by default any image with
+// more pixels than the original is. Zero values turn a check off.
+type Thresholds struct {
+	// MinAreaGain is how many pixels the larger image must add
+	MinAreaGain int
+	// MinAreaGainPercent is how much larger the area must be, in percent of the original
+	MinAreaGainPercent float64
+	// MinWidth and MinHeight are the smallest resolution worth downloading, in either orientation:
+	// the long side is compared to the larger of the two and the short side to the smaller, so a
+	// 1080x1920 portrait meets a 1920x1080 minimum
+	MinWidth  int
+	MinHeight int
+	// MaxSizeGrowthRatio limits how much faster the file size may grow than the area, 1 means
+	// the file may not grow by a larger percentage than the area. It is only checked when the
+	// size of the larger image is known, i.e. after it was downloaded.
+	MaxSizeGrowthRatio float64
+	// MaxUpscaleFactor is the largest width or height ratio between the larger image and the
+	// original that is believable, anything beyond it is most likely a different image or an upscale.
+	MaxUpscaleFactor float64
+	// RejectUpscaled rejects images whose native resolution, see EstimateNativeResolution, is no
+	// larger than the original. It is only checked when the image was downloaded.
+	RejectUpscaled bool
+}
+
+// ThresholdError is returned when a larger image was found but Thresholds rejected it.
+// It wraps ErrBelowThreshold or ErrSuspicious.
+type ThresholdError struct {
+	Reason string
+	err    error
+}
+
+func (e *ThresholdError) Error() string {
+	return fmt.Sprintf("%s: %s", e.err.Error(), e.Reason)
+}
+
+func (e *ThresholdError) Unwrap() error {
+	return e.err
+}
+
+// thresholds are checked by every Find and Get function
+var thresholds Thresholds
+
+// SetThresholds sets the checks larger images have to pass.
+// It is not safe to call while searches are in flight.
+func SetThresholds(t Thresholds) {
+	thresholds = t
+}
+
+// Check returns a *ThresholdError when larger is not worth using in place of original, or the
+// error from EstimateNativeResolution when RejectUpscaled is set and larger can't be decoded.
+func (t Thresholds) Check(original, larger *ImageData) error {
+	var originalArea = original.Width * original.Height
+	var gain = larger.Area - originalArea
+
+	if t.MaxUpscaleFactor > 0 && original.Width > 0 && original.Height > 0 {
+		var factor = math.Max(float64(larger.Width)/float64(original.Width), float64(larger.Height)/float64(original.Height))
+		if factor > t.MaxUpscaleFactor {
+			return &ThresholdError{err: ErrSuspicious, Reason: fmt.Sprintf("%.1fx larger than the original, max is %.1fx", factor, t.MaxUpscaleFactor)}
+		}
+	}
+
+	if t.MinAreaGain > 0 && gain < t.MinAreaGain {
+		return &ThresholdError{err: ErrBelowThreshold, Reason: fmt.Sprintf("adds %d pixels, min is %d", gain, t.MinAreaGain)}
+	}
+
+	if t.MinAreaGainPercent > 0 && originalArea > 0 {
+		var percent = float64(gain) / float64(originalArea) * 100
+		if percent < t.MinAreaGainPercent {
+			return &ThresholdError{err: ErrBelowThreshold, Reason: fmt.Sprintf("area is %.2f%% larger, min is %.2f%%", percent, t.MinAreaGainPercent)}
+		}
+	}
+
+	if max(larger.Width, larger.Height) < max(t.MinWidth, t.MinHeight) || min(larger.Width, larger.Height) < min(t.MinWidth, t.MinHeight) {
+		return &ThresholdError{err: ErrBelowThreshold, Reason: fmt.Sprintf("%dx%d is smaller than %dx%d", larger.Width, larger.Height, t.MinWidth, t.MinHeight)}
+	}
+
+	if t.RejectUpscaled && len(larger.Bytes) > 0 {
+		if larger.NativeWidth == 0 {
+			if err := EstimateNativeResolution(larger); err != nil {
+				return err
+			}
+		}
+		if larger.NativeWidth*larger.NativeHeight <= originalArea {
+			return &ThresholdError{err: ErrSuspicious, Reason: fmt.Sprintf("upscaled from about %dx%d, no more detail than the %dx%d original", larger.NativeWidth, larger.NativeHeight, original.Width, original.Height)}
+		}
+	}
+
+	if t.MaxSizeGrowthRatio > 0 && original.FileSize > 0 && larger.FileSize > 0 && gain > 0 {
+		var areaGrowth = float64(gain) / float64(originalArea)
+		var sizeGrowth = float64(larger.FileSize-original.FileSize) / float64(original.FileSize)
+		if sizeGrowth > areaGrowth*t.MaxSizeGrowthRatio {
+			return &ThresholdError{err: ErrSuspicious, Reason: fmt.Sprintf("file size grew %.2f%% for %.2f%% more area, max ratio is %.2f", sizeGrowth*100, areaGrowth*100, t.MaxSizeGrowthRatio)}
+		}
+	}
+
+	return nil
+}
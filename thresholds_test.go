@@ -0,0 +1,51 @@
+package imageupsizer
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThresholdsCheck(t *testing.T) {
+	t.Parallel()
+
+	var newImage = func(width, height int, size int64) *ImageData {
+		return &ImageData{Config: image.Config{Width: width, Height: height}, Area: width * height, FileSize: size}
+	}
+	var original = newImage(1000, 1000, 100000)
+
+	var tests = []struct {
+		name       string
+		thresholds Thresholds
+		larger     *ImageData
+		err        error
+	}{
+		{"no thresholds", Thresholds{}, newImage(1000, 1001, 0), nil},
+		{"min gain", Thresholds{MinAreaGain: 10000}, newImage(1000, 1009, 0), ErrBelowThreshold},
+		{"min gain met", Thresholds{MinAreaGain: 10000}, newImage(1000, 1010, 0), nil},
+		{"min gain percent", Thresholds{MinAreaGainPercent: 20}, newImage(1000, 1100, 0), ErrBelowThreshold},
+		{"min gain percent met", Thresholds{MinAreaGainPercent: 20}, newImage(1200, 1000, 0), nil},
+		{"min resolution", Thresholds{MinWidth: 1920, MinHeight: 1080}, newImage(1920, 1000, 0), ErrBelowThreshold},
+		{"min resolution met", Thresholds{MinWidth: 1920, MinHeight: 1080}, newImage(1920, 1080, 0), nil},
+		{"min resolution portrait", Thresholds{MinWidth: 1920, MinHeight: 1080}, newImage(1000, 1920, 0), ErrBelowThreshold},
+		{"min resolution portrait met", Thresholds{MinWidth: 1920, MinHeight: 1080}, newImage(1080, 1920, 0), nil},
+		{"min resolution short side", Thresholds{MinWidth: 1920, MinHeight: 1080}, newImage(2500, 1000, 0), ErrBelowThreshold},
+		{"max upscale", Thresholds{MaxUpscaleFactor: 4}, newImage(1000, 4100, 0), ErrSuspicious},
+		{"max upscale met", Thresholds{MaxUpscaleFactor: 4}, newImage(4000, 4000, 0), nil},
+		{"max size growth", Thresholds{MaxSizeGrowthRatio: 1}, newImage(2000, 1000, 250000), ErrSuspicious},
+		{"max size growth met", Thresholds{MaxSizeGrowthRatio: 1}, newImage(2000, 1000, 200000), nil},
+		{"max size growth unknown size", Thresholds{MaxSizeGrowthRatio: 1}, newImage(2000, 1000, 0), nil},
+	}
+
+	for _, test := range tests {
+		var err = test.thresholds.Check(original, test.larger)
+		if test.err == nil {
+			assert.NoError(t, err, test.name)
+			continue
+		}
+		assert.ErrorIs(t, err, test.err, test.name)
+		assert.True(t, isNegative(err), test.name)
+		assert.Equal(t, ClassNoLarger, ErrorClass(err), test.name)
+	}
+}
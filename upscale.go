@@ -0,0 +1,126 @@
+package imageupsizer
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// upscaleFactors are the upscale factors EstimateNativeResolution can tell apart
+var upscaleFactors = []float64{1.25, 1.5, 1.75, 2, 2.5, 3, 3.5, 4, 5, 6, 7, 8}
+
+const (
+	// analysisSize is the side of the square in the middle of the image that is analysed,
+	// upscaling is applied to the whole image so a part of it is enough and keeps large images fast.
+	analysisSize = 512
+	// upscaledResidualRatio is the residual ratio below which an image is considered upscaled,
+	// native photos stay above 0.2 even when they are compressed down to quality 60.
+	upscaledResidualRatio = 0.18
+	// residualRatioTolerance is how close to the lowest ratio a larger factor may be and still win,
+	// the ratio is about flat up to the real factor and the largest of them is the best estimate.
+	residualRatioTolerance = 1.15
+)
+
+// EstimateNativeResolution decodes img.Bytes, estimates the resolution the image really has
+// detail for and sets img.NativeWidth and img.NativeHeight. They are the same as the size of
+// the image unless it looks like an upscale of a smaller one.
+func EstimateNativeResolution(img *ImageData) error {
+	decoded, _, err := image.Decode(bytes.NewReader(img.Bytes))
+	if err != nil {
+		return fmt.Errorf("error decoding image: %s, error: %w", img.URL, err)
+	}
+
+	img.NativeWidth, img.NativeHeight = nativeResolution(decoded)
+	if swapsDimensions(img.Orientation) {
+		img.NativeWidth, img.NativeHeight = img.NativeHeight, img.NativeWidth
+	}
+	return nil
+}
+
+// nativeResolution is the size of img divided by how much it was upscaled
+func nativeResolution(img image.Image) (int, int) {
+	var bounds = img.Bounds()
+	var factor = upscaleFactor(analysisGray(img))
+	return int(float64(bounds.Dx()) / factor), int(float64(bounds.Dy()) / factor)
+}
+
+// upscaleFactor estimates how much g was upscaled from the downscale and re-upscale residual.
+// Shrinking an image by s and growing it back loses the detail finer than 1/s, an image that
+// was upscaled by f has next to no detail finer than 1/f so the residual stays tiny up to f.
+// Comparing the residual at s with the one at 2s makes the measure independent of how
+// busy the picture is: native images lose a steady share of their detail at every scale.
+func upscaleFactor(g *image.Gray) float64 {
+	var bounds = g.Bounds()
+	// too small to shrink by the largest factor twice over
+	if bounds.Dx() < 64 || bounds.Dy() < 64 {
+		return 1
+	}
+
+	var residuals = make(map[float64]float64)
+	var ratios = make([]float64, len(upscaleFactors))
+	var lowest = -1
+	for i, factor := range upscaleFactors {
+		for _, s := range []float64{factor, 2 * factor} {
+			if _, ok := residuals[s]; !ok {
+				residuals[s] = resampleResidual(g, s)
+			}
+		}
+		if residuals[2*factor] == 0 {
+			continue
+		}
+		ratios[i] = residuals[factor] / residuals[2*factor]
+		if lowest == -1 || ratios[i] < ratios[lowest] {
+			lowest = i
+		}
+	}
+
+	if lowest == -1 || ratios[lowest] >= upscaledResidualRatio {
+		return 1
+	}
+
+	var factor = upscaleFactors[lowest]
+	for i := lowest + 1; i < len(ratios); i++ {
+		if ratios[i] > 0 && ratios[i] <= ratios[lowest]*residualRatioTolerance {
+			factor = upscaleFactors[i]
+		}
+	}
+	return factor
+}
+
+// resampleResidual is the mean squared difference between g and g shrunk by s and grown back
+func resampleResidual(g *image.Gray, s float64) float64 {
+	var bounds = g.Bounds()
+	var small = image.NewGray(image.Rect(0, 0, max(1, int(float64(bounds.Dx())/s)), max(1, int(float64(bounds.Dy())/s))))
+	draw.CatmullRom.Scale(small, small.Bounds(), g, bounds, draw.Src, nil)
+	var back = image.NewGray(bounds)
+	draw.CatmullRom.Scale(back, bounds, small, small.Bounds(), draw.Src, nil)
+
+	// the edges are resampled from fewer pixels, leave them out
+	const border = 8
+	var sum float64
+	var count int
+	for y := bounds.Min.Y + border; y < bounds.Max.Y-border; y++ {
+		for x := bounds.Min.X + border; x < bounds.Max.X-border; x++ {
+			var d = float64(g.GrayAt(x, y).Y) - float64(back.GrayAt(x, y).Y)
+			sum += d * d
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// analysisGray is the luma of the analysisSize square in the middle of img
+func analysisGray(img image.Image) *image.Gray {
+	var bounds = img.Bounds()
+	var center = image.Pt(bounds.Min.X+bounds.Dx()/2, bounds.Min.Y+bounds.Dy()/2)
+	var crop = image.Rect(center.X-analysisSize/2, center.Y-analysisSize/2, center.X+analysisSize/2, center.Y+analysisSize/2).Intersect(bounds)
+
+	var gray = image.NewGray(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, crop.Min, draw.Src)
+	return gray
+}
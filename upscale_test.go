@@ -0,0 +1,62 @@
+package imageupsizer
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/draw"
+)
+
+// resample shrinks img by factor and grows it back to its size, the way a blurry upscale of a thumbnail is made
+func resample(img image.Image, factor float64) image.Image {
+	var bounds = img.Bounds()
+	var small = image.NewRGBA(image.Rect(0, 0, int(float64(bounds.Dx())/factor), int(float64(bounds.Dy())/factor)))
+	draw.CatmullRom.Scale(small, small.Bounds(), img, bounds, draw.Src, nil)
+	var upscaled = image.NewRGBA(bounds)
+	draw.CatmullRom.Scale(upscaled, bounds, small, small.Bounds(), draw.Src, nil)
+	return upscaled
+}
+
+func TestEstimateNativeResolution(t *testing.T) {
+	t.Parallel()
+
+	var img = readTestImage(t)
+
+	var native = encodeJPEG(t, img, 90)
+	assert.NoError(t, EstimateNativeResolution(native))
+	assert.Equal(t, native.Width, native.NativeWidth)
+	assert.Equal(t, native.Height, native.NativeHeight)
+
+	for _, factor := range []float64{2, 3, 4} {
+		var upscaled = encodeJPEG(t, resample(img, factor), 90)
+		assert.NoError(t, EstimateNativeResolution(upscaled))
+		// the estimate is conservative, never more than the real factor and not far off it
+		assert.GreaterOrEqual(t, upscaled.NativeWidth, int(float64(upscaled.Width)/factor), factor)
+		assert.Less(t, upscaled.NativeWidth, int(float64(upscaled.Width)/factor*1.3), factor)
+	}
+
+	assert.Error(t, EstimateNativeResolution(&ImageData{Bytes: []byte("not an image")}))
+}
+
+func TestThresholdsRejectUpscaled(t *testing.T) {
+	t.Parallel()
+
+	var img = readTestImage(t)
+	var bounds = img.Bounds()
+
+	// the original is a thumbnail, the candidate is the same picture upscaled 3x from it
+	var thumbnail = image.NewRGBA(image.Rect(0, 0, bounds.Dx()/3, bounds.Dy()/3))
+	draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), img, bounds, draw.Src, nil)
+	var original = encodeJPEG(t, thumbnail, 90)
+
+	var upscaled = image.NewRGBA(bounds)
+	draw.CatmullRom.Scale(upscaled, bounds, thumbnail, thumbnail.Bounds(), draw.Src, nil)
+
+	var thresholds = Thresholds{RejectUpscaled: true}
+	assert.ErrorIs(t, thresholds.Check(original, encodeJPEG(t, upscaled, 90)), ErrSuspicious)
+	assert.NoError(t, thresholds.Check(original, encodeJPEG(t, img, 90)))
+
+	// without the bytes there is nothing to analyse
+	assert.NoError(t, thresholds.Check(original, &ImageData{Config: image.Config{Width: bounds.Dx(), Height: bounds.Dy()}, Area: bounds.Dx() * bounds.Dy()}))
+}